@@ -0,0 +1,74 @@
+package main
+
+import (
+	sctx "context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/kata-contrib/runs/pkg/monitor"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+var attachCommand = cli.Command{
+	Name:  "attach",
+	Usage: "attach to the IO of a running container",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container.`,
+	Description: `The attach command connects to the monitor of a container: the output of
+the container is copied to stdout and stderr and stdin is copied to the
+container, its end closing the stdin of the container.
+
+Any number of clients can attach to a container, but only one of them writes to
+its stdin: the first one to ask for it, until it detaches or another client
+takes stdin over with --take-stdin.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-stdin",
+			Usage: "do not attach stdin",
+		},
+		cli.BoolFlag{
+			Name:  "take-stdin",
+			Usage: "take stdin over from the client writing to it",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+		id := context.Args().First()
+		info, err := monitor.LoadInfo(context.GlobalString("root"), id)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("container %s has no running monitor", id)
+			}
+			return err
+		}
+		if context.Bool("no-stdin") && context.Bool("take-stdin") {
+			return errors.New("--no-stdin cannot be used with --take-stdin")
+		}
+		var stdin io.Reader = os.Stdin
+		mode := monitor.WantStdin
+		switch {
+		case context.Bool("no-stdin"):
+			stdin = nil
+			mode = monitor.NoStdin
+		case context.Bool("take-stdin"):
+			mode = monitor.TakeStdin
+		}
+		ctx, cancel := signal.NotifyContext(sctx.Background(), unix.SIGINT, unix.SIGTERM)
+		defer cancel()
+		conn, err := monitor.Dial(ctx, info.Socket, mode)
+		if err != nil {
+			return err
+		}
+		if stdin != nil && !conn.HasStdin() {
+			fmt.Fprintf(os.Stderr, "stdin of container %s is attached elsewhere, use --take-stdin to take it over\n", id)
+		}
+		return conn.Copy(ctx, stdin, os.Stdout, os.Stderr)
+	},
+}
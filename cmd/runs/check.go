@@ -0,0 +1,275 @@
+package main
+
+import (
+	sctx "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	runtimeoptions "github.com/containerd/containerd/pkg/runtimeoptions/v1"
+	"github.com/kata-contrib/runs/pkg/config"
+	"github.com/kata-contrib/runs/pkg/shim"
+	"github.com/opencontainers/runc/libcontainer/cgroups"
+	"github.com/pelletier/go-toml"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+// checkResult is the outcome of a single preflight check.
+type checkResult struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func passed(name, detail string) checkResult {
+	return checkResult{Name: name, OK: true, Detail: detail}
+}
+
+func failed(name string, err error) checkResult {
+	return checkResult{Name: name, Detail: err.Error()}
+}
+
+var checkCommand = cli.Command{
+	Name:  "check",
+	Usage: "check that the host and the configured shim runtimes are ready to run containers",
+	Description: `The check command runs preflight diagnostics: it resolves every configured
+runtime to its shim binary and runs its version command, verifies that the
+state root, the FIFO directory and the events address are usable, and checks
+the kernel features the runtimes need. It exits non-zero if any check fails.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format, f",
+			Value: "text",
+			Usage: `select one of: text or json`,
+		},
+		cli.StringFlag{
+			Name:  "fifo-dir",
+			Usage: "directory the IO FIFOs are created in, the default of create when not set",
+		},
+		cli.StringFlag{
+			Name:  "events-address",
+			Usage: "TTRPC address shims publish events to, defaults to the events server of the state root",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 0, exactArgs); err != nil {
+			return err
+		}
+		cfg, err := loadConfig(context)
+		if err != nil {
+			return err
+		}
+
+		eventsAddress := context.String("events-address")
+		// The events server of the state root is only started by create.
+		ownServer := eventsAddress == ""
+		if ownServer {
+			eventsAddress = shim.EventsAddress(context.GlobalString("root"))
+		}
+
+		runtimes := checkedRuntimes(cfg)
+		var results []checkResult
+		for _, name := range sortedKeys(runtimes) {
+			results = append(results, checkRuntime(name, runtimes[name])...)
+		}
+		results = append(results,
+			checkWritableDir("state root", context.GlobalString("root")),
+			checkWritableDir("fifo dir", fifoDir(context, "default")),
+			checkFIFO(),
+			checkEventsAddress(eventsAddress, ownServer),
+			checkCgroups(),
+		)
+		for _, r := range runtimes {
+			if r.Type == shim.KataRuntime {
+				results = append(results,
+					checkDevice("kvm", "/dev/kvm"),
+					checkDevice("vhost-vsock", "/dev/vhost-vsock"),
+					checkDevice("vhost-net", "/dev/vhost-net"),
+				)
+				break
+			}
+		}
+
+		switch context.String("format") {
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+			fmt.Fprint(w, "CHECK\tRESULT\tDETAIL\n")
+			for _, r := range results {
+				result := "pass"
+				if !r.OK {
+					result = "FAIL"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", r.Name, result, r.Detail)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		case "json":
+			if err := json.NewEncoder(os.Stdout).Encode(results); err != nil {
+				return err
+			}
+		default:
+			return errors.New("invalid format option")
+		}
+
+		var failures int
+		for _, r := range results {
+			if !r.OK {
+				failures++
+			}
+		}
+		if failures > 0 {
+			return fmt.Errorf("%d of %d checks failed", failures, len(results))
+		}
+		return nil
+	},
+}
+
+// checkedRuntimes returns the configured runtime handlers, or the default
+// kata runtime when none are configured.
+func checkedRuntimes(cfg *config.Config) map[string]config.Runtime {
+	if len(cfg.Runtimes) > 0 {
+		return cfg.Runtimes
+	}
+	return map[string]config.Runtime{
+		shim.KataRuntime: {Type: shim.KataRuntime},
+	}
+}
+
+func sortedKeys(m map[string]config.Runtime) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkRuntime resolves the shim binary of a runtime handler, runs its
+// version command and validates its options.
+func checkRuntime(name string, r config.Runtime) []checkResult {
+	check := "runtime " + name
+	path, err := shim.ResolveRuntimePath(r.Type)
+	if err != nil {
+		return []checkResult{failed(check, err)}
+	}
+	ctx, cancel := sctx.WithTimeout(sctx.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, "-v").CombinedOutput()
+	if err != nil {
+		return []checkResult{failed(check, fmt.Errorf("%s -v: %s: %w", path, strings.TrimSpace(string(out)), err))}
+	}
+	version := strings.Fields(strings.TrimSpace(string(out)))
+	results := []checkResult{passed(check, path+": "+strings.Join(version, " "))}
+
+	if r.Options == "" {
+		return results
+	}
+	check = "runtime " + name + " options"
+	opts, err := shim.NewRuntimeOptions(r.Type, r.Options, nil)
+	if err != nil {
+		return append(results, failed(check, err))
+	}
+	if o, ok := opts.(*runtimeoptions.Options); ok && o.ConfigPath != "" {
+		if _, err := toml.LoadFile(o.ConfigPath); err != nil {
+			return append(results, failed(check, fmt.Errorf("runtime config %s: %w", o.ConfigPath, err)))
+		}
+		return append(results, passed(check, o.ConfigPath))
+	}
+	return append(results, passed(check, r.Options))
+}
+
+// checkWritableDir checks that files can be created in dir, or that its
+// nearest existing parent allows creating it. It creates nothing.
+func checkWritableDir(name, dir string) checkResult {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return failed(name, err)
+	}
+	path := abs
+	for {
+		st, err := os.Stat(path)
+		if err == nil {
+			if !st.IsDir() {
+				return failed(name, fmt.Errorf("%s is not a directory", path))
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return failed(name, err)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return failed(name, err)
+		}
+		path = parent
+	}
+	if err := unix.Access(path, unix.W_OK|unix.X_OK); err != nil {
+		return failed(name, fmt.Errorf("%s is not writable: %w", path, err))
+	}
+	if path != abs {
+		return passed(name, dir+" (created on first use)")
+	}
+	return passed(name, dir)
+}
+
+// checkFIFO checks that named pipes can be created on the host.
+func checkFIFO() checkResult {
+	const name = "fifo support"
+	dir, err := os.MkdirTemp("", "runs-check-")
+	if err != nil {
+		return failed(name, err)
+	}
+	defer os.RemoveAll(dir)
+	if err := unix.Mkfifo(filepath.Join(dir, "fifo"), 0o600); err != nil {
+		return failed(name, err)
+	}
+	return passed(name, "")
+}
+
+// checkEventsAddress checks that something listens on the address shims
+// publish their events to. The events server of the state root, ownServer,
+// may not run yet: create starts it.
+func checkEventsAddress(address string, ownServer bool) checkResult {
+	const name = "events address"
+	conn, err := net.DialTimeout("unix", address, time.Second)
+	if err != nil {
+		if ownServer && (errors.Is(err, unix.ENOENT) || errors.Is(err, unix.ECONNREFUSED)) {
+			return passed(name, address+": not running, started on create")
+		}
+		return failed(name, err)
+	}
+	conn.Close()
+	return passed(name, address)
+}
+
+func checkCgroups() checkResult {
+	const name = "cgroups"
+	if _, err := os.Stat("/sys/fs/cgroup"); err != nil {
+		return failed(name, err)
+	}
+	if cgroups.IsCgroup2UnifiedMode() {
+		return passed(name, "v2 (unified)")
+	}
+	return passed(name, "v1")
+}
+
+func checkDevice(name, path string) checkResult {
+	st, err := os.Stat(path)
+	if err != nil {
+		return failed(name, err)
+	}
+	if st.Mode()&os.ModeCharDevice == 0 {
+		return failed(name, fmt.Errorf("%s is not a character device", path))
+	}
+	return passed(name, path)
+}
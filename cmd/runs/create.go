@@ -2,21 +2,27 @@ package main
 
 import (
 	sctx "context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/urfave/cli"
 
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/pkg/cri/annotations"
 	"github.com/containerd/containerd/protobuf"
 	"github.com/containerd/containerd/runtime"
 	"github.com/kata-contrib/runs/pkg/cio"
+	"github.com/kata-contrib/runs/pkg/logdriver"
 	"github.com/kata-contrib/runs/pkg/shim"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 
 	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/opencontainers/runtime-spec/specs-go"
 )
 
 type stdinCloser struct {
@@ -34,6 +40,20 @@ func (s *stdinCloser) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// setSandboxAnnotations marks the spec with the CRI annotations shims use to
+// tell a sandbox apart from the containers that join it.
+func setSandboxAnnotations(spec *specs.Spec, id, sandboxID string) {
+	if spec.Annotations == nil {
+		spec.Annotations = make(map[string]string)
+	}
+	if id == sandboxID {
+		spec.Annotations[annotations.ContainerType] = annotations.ContainerTypeSandbox
+	} else {
+		spec.Annotations[annotations.ContainerType] = annotations.ContainerTypeContainer
+	}
+	spec.Annotations[annotations.SandboxID] = sandboxID
+}
+
 var createCommand = cli.Command{
 	Name:  "create",
 	Usage: "create a container",
@@ -50,7 +70,7 @@ The specification file includes an args parameter. The args parameter is used
 to specify command(s) that get run when the container is started. To change the
 command(s) that get executed on start, edit the args parameter of the spec. See
 "runc spec --help" for more explanation.`,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		cli.StringFlag{
 			Name:  "bundle, b",
 			Value: "",
@@ -78,123 +98,278 @@ command(s) that get executed on start, edit the args parameter of the spec. See
 			Name:  "preserve-fds",
 			Usage: "Pass N additional file descriptors to the container (stdio + $LISTEN_FDS + N in total)",
 		},
-	},
+		cli.StringFlag{
+			Name:  "runtime",
+			Value: shim.KataRuntime,
+			Usage: "runtime handler from the config, name of the shim runtime (e.g. io.containerd.runc.v2) or absolute path to the shim binary",
+		},
+		cli.StringFlag{
+			Name:  "runtime-options",
+			Value: "",
+			Usage: "path to a JSON file with the typed options passed to the shim",
+		},
+		cli.StringSliceFlag{
+			Name:  "runtime-option",
+			Usage: "set a shim option as key=value, overriding --runtime-options (e.g. ConfigPath=/etc/kata/configuration.toml)",
+		},
+		cli.StringFlag{
+			Name:  "sandbox",
+			Value: "",
+			Usage: "id of the sandbox to run the container in; the sandbox is created when it matches the container id",
+		},
+		logURIFlag,
+		timeoutFlag,
+	}, append(append(append(ioFlags, containerLogFlags...), shimLogFlags...), onExitFlags...)...),
 	Action: func(context *cli.Context) error {
-		var (
-			id  string
-			ref string
-		//	config = context.IsSet("config")
-		)
-
-		if 1 == 1 {
-			id = context.Args().First()
-			if context.NArg() > 1 {
-				return fmt.Errorf("with spec config file, only container id should be provided: %w", errdefs.ErrInvalidArgument)
-			}
-		} else {
-			id = context.Args().Get(1)
-			ref = context.Args().First()
-			if ref == "" {
-				return fmt.Errorf("image ref must be provided: %w", errdefs.ErrInvalidArgument)
-			}
+		return createContainer(context)
+	},
+}
+
+// createContainer creates the container of the bundle in the current
+// directory, with the IO owned by its monitor or sent to its log URI.
+func createContainer(context *cli.Context) (retErr error) {
+	var (
+		id  string
+		ref string
+	//	config = context.IsSet("config")
+	)
+
+	if 1 == 1 {
+		id = context.Args().First()
+		if context.NArg() > 1 {
+			return fmt.Errorf("with spec config file, only container id should be provided: %w", errdefs.ErrInvalidArgument)
 		}
-		if id == "" {
-			return fmt.Errorf("container id must be provided: %w", errdefs.ErrInvalidArgument)
+	} else {
+		id = context.Args().Get(1)
+		ref = context.Args().First()
+		if ref == "" {
+			return fmt.Errorf("image ref must be provided: %w", errdefs.ErrInvalidArgument)
 		}
+	}
+	if id == "" {
+		return fmt.Errorf("container id must be provided: %w", errdefs.ErrInvalidArgument)
+	}
+	if err := checkIOFlags(context); err != nil {
+		return err
+	}
+
+	root := context.GlobalString("root")
+	containerRoot, err := securejoin.SecureJoin(root, id)
+	if err != nil {
+		return err
+	}
+	os.Stat(containerRoot)
+	os.MkdirAll(containerRoot, 0711)
+	os.Chown(containerRoot, unix.Geteuid(), unix.Getegid())
+
+	ctx := namespaces.WithNamespace(sctx.Background(), "default")
 
-		containerRoot, err := securejoin.SecureJoin("/run/runs", id)
+	spec, err := readSpec(specConfig)
+	if err != nil {
+		return err
+	}
+	if err := validateSpec(spec, "."); err != nil {
+		return err
+	}
+	// run --tty allocates a terminal whatever the spec says.
+	if context.Bool("tty") && spec.Process != nil {
+		spec.Process.Terminal = true
+	}
+	sandboxID := context.String("sandbox")
+	if sandboxID != "" {
+		setSandboxAnnotations(spec, id, sandboxID)
+	}
+
+	specAny, err := protobuf.MarshalAnyToProto(spec)
+	if err != nil {
+		return err
+	}
+
+	runtimeName := context.String("runtime")
+	if err := setupTimeouts(context, runtimeName); err != nil {
+		return err
+	}
+	optionsFile := context.String("runtime-options")
+	runsConfig, err := loadConfig(context)
+	if err != nil {
+		return err
+	}
+	// A runtime handler from the config maps onto its shim runtime and
+	// provides the default options file.
+	handler, isHandler := runsConfig.Runtimes[runtimeName]
+	if isHandler {
+		runtimeName = handler.Type
+		if optionsFile == "" {
+			optionsFile = handler.Options
+		}
+	}
+	overrides, err := shim.ParseRuntimeOptions(context.StringSlice("runtime-option"))
+	if err != nil {
+		return err
+	}
+	// Pooled shims are started with the handler's options, so they are
+	// only used when the container does not ask for others.
+	var pools map[string]shim.PoolConfig
+	if isHandler && handler.Pool.Size > 0 && optionsFile == handler.Options && len(overrides) == 0 {
+		pool, err := handlerPool(context.String("runtime"), handler)
 		if err != nil {
 			return err
 		}
-		os.Stat(containerRoot)
-		os.MkdirAll(containerRoot, 0711)
-		os.Chown(containerRoot, unix.Geteuid(), unix.Getegid())
+		pools = map[string]shim.PoolConfig{runtimeName: pool}
+	}
 
-		ctx := namespaces.WithNamespace(sctx.Background(), "default")
+	shimLogger, err := newShimLogger(context)
+	if err != nil {
+		return err
+	}
+	eventsAddress, err := ensureEventsServer(context)
+	if err != nil {
+		return err
+	}
+	shimManager, err := shim.NewShimManager(ctx, &shim.ManagerConfig{
+		State:        root,
+		Address:      publishAddress(context),
+		TTRPCAddress: eventsAddress,
+		ShimLogger:   shimLogger,
+		Pools:        pools,
+	})
+	if err != nil {
+		return err
+	}
+	runtimeOpts, err := shim.NewRuntimeOptions(runtimeName, optionsFile, overrides)
+	if err != nil {
+		return err
+	}
 
-		shimManager, err := shim.NewShimManager(ctx, &shim.ManagerConfig{
-			State:        "/var/run/runs",
-			Address:      "/run/containerd/containerd.sock",
-			TTRPCAddress: "/run/containerd/containerd.sock.ttrpc",
-		})
+	terminal := spec.Process != nil && spec.Process.Terminal
+	var taskIO runtime.IO
+	logURI := context.String("log-uri")
+	logConfig, err := containerLogConfig(context)
+	if err != nil {
+		return err
+	}
+	logFormat := logConfig.LogFormat
+	if logURI != "" && logFormat != cio.LogFormatRaw {
+		return errors.New("--container-log-format applies to the log of the monitor, not to --log-uri")
+	}
+	logDriver := logConfig.LogDriver
+	if logURI != "" && logDriver != logdriver.File {
+		return errors.New("--log-driver applies to the monitor, not to --log-uri")
+	}
+	var fifos string
+	switch {
+	case context.Bool("null-io"):
+		// The process gets no IO at all, there is nothing for a monitor
+		// to own.
+		taskIO.Terminal = terminal
+	case logURI != "":
+		// The shim sends the output straight to the log URI, there is
+		// nothing for a monitor to own.
+		creator, err := logURICreator(logURI, terminal)
 		if err != nil {
 			return err
 		}
-		spec, err := loadSpec(specConfig)
+		i, err := creator(id)
 		if err != nil {
 			return err
 		}
-
-		specAny, err := protobuf.MarshalAnyToProto(spec)
+		cfg := i.Config()
+		logURI = cfg.Stdout
+		taskIO = runtime.IO{
+			Stdout:   cfg.Stdout,
+			Stderr:   cfg.Stderr,
+			Terminal: terminal,
+		}
+	default:
+		// The monitor owns the FIFOs for as long as the container runs,
+		// the shim writes to them long after create returned.
+		monitorInfo, err := startMonitor(context, id, spec.Annotations[annotations.ContainerName], terminal)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to start monitor: %w", err)
+		}
+		defer func() {
+			if retErr != nil {
+				unix.Kill(monitorInfo.Pid, unix.SIGTERM)
+			}
+		}()
+		taskIO = runtime.IO{
+			Stdin:    monitorInfo.Stdin,
+			Stdout:   monitorInfo.Stdout,
+			Stderr:   monitorInfo.Stderr,
+			Terminal: monitorInfo.Terminal,
 		}
+		fifos = filepath.Dir(monitorInfo.Stdout)
+	}
+
+	// container, err := client.LoadContainer(ctx, id)
 
-		// con = false
-		// nullIO = context.Bool("null-io")
-		// context.String("log-uri")
-		stdinC := &stdinCloser{
-			stdin: os.Stdin,
+	opts := runtime.CreateOpts{
+		Spec:      specAny,
+		IO:        taskIO,
+		Runtime:   runtimeName,
+		SandboxID: sandboxID,
+	}
+	if runtimeOpts != nil {
+		if opts.RuntimeOptions, err = protobuf.MarshalAnyToProto(runtimeOpts); err != nil {
+			return err
 		}
+	}
 
-		ioOpts := []cio.Opt{cio.WithFIFODir(context.String("fifo-dir"))}
-		ioCreator := cio.NewCreator(append([]cio.Opt{cio.WithStreams(stdinC, os.Stdout, os.Stderr)}, ioOpts...)...)
+	// for _, m := range spec.Mounts {
+	// 	//cm, err := createLibcontainerMount(cwd, m)
+	// 	//if err != nil {
+	// 	//	return nil, fmt.Errorf("invalid mount %+v: %w", m, err)
+	// 	//}
+	// 	opts.Rootfs = append(opts.Rootfs, mount.Mount{
+	// 		Type:    m.Type,
+	// 		// Destination:  m.Destination,
+	// 		Source:  m.Source,
+	// 		Options: m.Options,
+	// 	})
+	// }
 
-		i, err := ioCreator(id)
-		cfg := i.Config()
+	// opts.Rootfs = append(opts.Rootfs, mount.Mount{
+	// 	Type:    m.Type,
+	// 	Source:  "./rootfs",
+	// 	Options: [],
+	// })
 
-		// container, err := client.LoadContainer(ctx, id)
-
-		opts := runtime.CreateOpts{
-			Spec: specAny,
-			IO: runtime.IO{
-				Stdin:    cfg.Stdin,
-				Stdout:   cfg.Stdout,
-				Stderr:   cfg.Stderr,
-				Terminal: cfg.Terminal,
-			},
-			// TaskOptions:    r.Options,
-			// SandboxID:      container.SandboxID,
+	taskManager := shim.NewTaskManager(shimManager)
+	if _, err := taskManager.Create(ctx, id, opts); err != nil {
+		return err
+	}
+	err = shim.UpdateState(root, id, func(state *shim.State) error {
+		state.LogURI = logURI
+		state.LogFormat = logFormat
+		if fifos != "" && logDriver != logdriver.File {
+			state.LogDriver = logDriver
 		}
+		state.FIFODir = fifos
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record IO settings: %w", err)
+	}
+	if err := setOnExit(context, id); err != nil {
+		return err
+	}
 
-		opts.Runtime = "io.containerd.kata.v2"
-
-		// for _, m := range spec.Mounts {
-		// 	//cm, err := createLibcontainerMount(cwd, m)
-		// 	//if err != nil {
-		// 	//	return nil, fmt.Errorf("invalid mount %+v: %w", m, err)
-		// 	//}
-		// 	opts.Rootfs = append(opts.Rootfs, mount.Mount{
-		// 		Type:    m.Type,
-		// 		// Destination:  m.Destination,
-		// 		Source:  m.Source,
-		// 		Options: m.Options,
-		// 	})
-		// }
-
-		// opts.Rootfs = append(opts.Rootfs, mount.Mount{
-		// 	Type:    m.Type,
-		// 	Source:  "./rootfs",
-		// 	Options: [],
-		// })
-
-		taskManager := shim.NewTaskManager(shimManager)
-		taskManager.Create(ctx, id, opts)
-		if err != nil {
-			return err
+	if pool, ok := pools[runtimeName]; ok && pool.Refill {
+		if err := refillPool(context, pool.Name); err != nil {
+			logrus.WithError(err).Warn("failed to refill shim pool")
 		}
+	}
 
-		// if err := saveContainerState(ctx, id, opts); err != nil {
-		// return err
-		// }
-
-		// status, err := startContainer(context, CT_ACT_CREATE, nil)
-		// if err == nil {
-		// 	// exit with the container's exit status so any external supervisor
-		// 	// is notified of the exit with the correct exit status.
-		// 	os.Exit(status)
-		// }
-		// return fmt.Errorf("runc create failed: %w", err)
-		return nil
-	},
+	// if err := saveContainerState(ctx, id, opts); err != nil {
+	// return err
+	// }
+
+	// status, err := startContainer(context, CT_ACT_CREATE, nil)
+	// if err == nil {
+	// 	// exit with the container's exit status so any external supervisor
+	// 	// is notified of the exit with the correct exit status.
+	// 	os.Exit(status)
+	// }
+	// return fmt.Errorf("runc create failed: %w", err)
+	return nil
 }
@@ -1,28 +1,27 @@
 package main
 
 import (
+	sctx "context"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"time"
+	"strings"
 
-	"github.com/opencontainers/runc/libcontainer"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/runtime"
 	"github.com/urfave/cli"
 
+	"github.com/kata-contrib/runs/pkg/shim"
 	"golang.org/x/sys/unix"
 )
 
-func killContainer(container *libcontainer.Container) error {
-	_ = container.Signal(unix.SIGKILL, false)
-	for i := 0; i < 100; i++ {
-		time.Sleep(100 * time.Millisecond)
-		if err := container.Signal(unix.Signal(0), false); err != nil {
-			destroy(container)
-			return nil
-		}
+func killContainer(ctx sctx.Context, task runtime.Task) error {
+	if err := task.Kill(ctx, uint32(unix.SIGKILL), false); err != nil {
+		return err
 	}
-	return errors.New("container init still running")
+	_, err := task.Wait(ctx)
+	return err
 }
 
 var deleteCommand = cli.Command{
@@ -41,8 +40,9 @@ status of "ubuntu01" as "stopped" the following will delete resources held for
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "force, f",
-			Usage: "Forcibly deletes the container if it is still running (uses SIGKILL)",
+			Usage: "Forcibly deletes the container if it is still running (uses SIGKILL), and the containers of a sandbox first",
 		},
+		timeoutFlag,
 	},
 	Action: func(context *cli.Context) error {
 		if err := checkArgs(context, 1, exactArgs); err != nil {
@@ -50,38 +50,83 @@ status of "ubuntu01" as "stopped" the following will delete resources held for
 		}
 
 		id := context.Args().First()
-		force := context.Bool("force")
-		container, err := getContainer(context)
-		if err != nil {
-			if errors.Is(err, libcontainer.ErrNotExist) {
-				// if there was an aborted start or something of the sort then the container's directory could exist but
-				// libcontainer does not see it because the state.json file inside that directory was never created.
-				path := filepath.Join(context.GlobalString("root"), id)
-				if e := os.RemoveAll(path); e != nil {
-					fmt.Fprintf(os.Stderr, "remove %s: %v\n", path, e)
-				}
-				if force {
-					return nil
-				}
-			}
+		root := context.GlobalString("root")
+		if err := setupTimeouts(context, containerRuntime(context, id)); err != nil {
 			return err
 		}
-		s, err := container.Status()
+
+		ctx := namespaces.WithNamespace(sctx.Background(), "default")
+		shimManager, err := shim.NewShimManager(ctx, &shim.ManagerConfig{
+			State: root,
+		})
 		if err != nil {
 			return err
 		}
-		switch s {
-		case libcontainer.Stopped:
-			destroy(container)
-		case libcontainer.Created:
-			return killContainer(container)
-		default:
+		return deleteContainer(ctx, shim.NewTaskManager(shimManager), root, id, context.Bool("force"))
+	},
+}
+
+// deleteContainer deletes the task, the shim and the state of a container. A
+// sandbox is only deleted once its containers are, which force deletes first.
+func deleteContainer(ctx sctx.Context, taskManager *shim.TaskManager, root, id string, force bool) error {
+	path := filepath.Join(root, id)
+	task, err := taskManager.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// if there was an aborted start or something of the sort then the container's directory could exist but
+			// the state.json file inside that directory was never created.
+			if e := os.RemoveAll(path); e != nil {
+				fmt.Fprintf(os.Stderr, "remove %s: %v\n", path, e)
+			}
 			if force {
-				return killContainer(container)
+				return nil
+			}
+		}
+		return err
+	}
+	saved, err := shim.LoadState(root, id)
+	if err != nil {
+		return err
+	}
+	if saved.SandboxID == "" || saved.SandboxID == id {
+		members, err := shim.SandboxMembers(root, id)
+		if err != nil {
+			return err
+		}
+		if len(members) > 0 && !force {
+			return fmt.Errorf("cannot delete sandbox %s that still has containers %s, delete them first or use --force", id, strings.Join(members, ", "))
+		}
+		for _, member := range members {
+			if err := deleteContainer(ctx, taskManager, root, member, true); err != nil {
+				return fmt.Errorf("failed to delete container %s of sandbox %s: %w", member, id, err)
 			}
-			return fmt.Errorf("cannot delete container %s that is not stopped: %s", id, s)
 		}
+	}
 
-		return nil
-	},
+	state, err := task.State(ctx)
+	if err != nil {
+		return err
+	}
+	switch state.Status {
+	case runtime.StoppedStatus:
+	case runtime.CreatedStatus:
+		if err := killContainer(ctx, task); err != nil {
+			return err
+		}
+	default:
+		if !force {
+			return fmt.Errorf("cannot delete container %s that is not stopped: %s", id, statusString(state.Status))
+		}
+		if err := killContainer(ctx, task); err != nil {
+			return err
+		}
+	}
+
+	if _, err := taskManager.Delete(ctx, id); err != nil {
+		return err
+	}
+	if err := removeFIFODir(saved); err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	sctx "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/runtime"
+	"github.com/kata-contrib/runs/pkg/shim"
+	"github.com/kata-contrib/runs/pkg/sink"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+var eventsCommand = cli.Command{
+	Name:  "events",
+	Usage: "display the events recorded for a container",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container.`,
+	Description: `The events command displays the events recorded in the journal of a
+container, one JSON object per line. The "type" of an event is "start", "exit"
+or "oom" for the task events and the topic for the others.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "follow, f",
+			Usage: "keep displaying new events until the container is deleted",
+		},
+		cli.DurationFlag{
+			Name:  "interval",
+			Value: time.Second,
+			Usage: "set the polling interval for new events when following",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+		id := context.Args().First()
+		root := context.GlobalString("root")
+		if _, err := shim.LoadState(root, id); err != nil {
+			return fmt.Errorf("container %s: %w", id, err)
+		}
+		interval := context.Duration("interval")
+		if interval <= 0 {
+			return errors.New("duration interval must be greater than 0")
+		}
+
+		var offset int64
+		enc := json.NewEncoder(os.Stdout)
+		for {
+			n, err := printEvents(enc, shim.JournalPath(root, id), offset)
+			if err != nil {
+				return err
+			}
+			offset = n
+			if !context.Bool("follow") {
+				return nil
+			}
+			if _, err := os.Stat(filepath.Join(root, id)); os.IsNotExist(err) {
+				return nil
+			}
+			time.Sleep(interval)
+		}
+	},
+}
+
+// event is an entry of the journal as displayed by "runs events".
+type event struct {
+	Type      string          `json:"type"`
+	ID        string          `json:"id"`
+	Timestamp time.Time       `json:"timestamp"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// eventType returns the type of event displayed for a journal topic.
+func eventType(topic string) string {
+	switch topic {
+	case runtime.TaskStartEventTopic:
+		return "start"
+	case runtime.TaskExitEventTopic:
+		return "exit"
+	case runtime.TaskOOMEventTopic:
+		return "oom"
+	default:
+		return topic
+	}
+}
+
+// printEvents prints the complete journal entries found after offset in a
+// journal, and returns the offset to continue from.
+func printEvents(enc *json.Encoder, path string, offset int64) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return offset, nil
+		}
+		return offset, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			// A partial line is read again once it is complete.
+			if err == io.EOF {
+				return offset, nil
+			}
+			return offset, err
+		}
+		offset += int64(len(line))
+		var entry shim.JournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			logrus.WithError(err).Warn("skipping malformed journal entry")
+			continue
+		}
+		if err := enc.Encode(event{
+			Type:      eventType(entry.Topic),
+			ID:        entry.ID,
+			Timestamp: entry.Timestamp,
+			Data:      entry.Event,
+		}); err != nil {
+			return offset, err
+		}
+	}
+}
+
+var eventsServerCommand = cli.Command{
+	Name:   "events-server",
+	Usage:  "receive the events published by shims and record them in the container state",
+	Hidden: true,
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 0, exactArgs); err != nil {
+			return err
+		}
+		sinks, err := newDispatcher(context)
+		if err != nil {
+			return err
+		}
+		defer closeDispatcher(sinks)
+
+		ctx, cancel := signal.NotifyContext(sctx.Background(), unix.SIGINT, unix.SIGTERM)
+		defer cancel()
+		return shim.NewEventsServer(context.GlobalString("root"), func(entry *shim.JournalEntry) {
+			sinks.Publish(entry)
+			notifyExit(context, entry)
+		}).Serve(ctx)
+	},
+}
+
+// sinkFlushTimeout bounds how long runs waits on exit for queued events to be
+// delivered to the sinks.
+const sinkFlushTimeout = 10 * time.Second
+
+// newDispatcher starts delivering events to the sinks of the runs config.
+// The events server reads the config when it starts.
+func newDispatcher(context *cli.Context) (*sink.Dispatcher, error) {
+	cfg, err := loadConfig(context)
+	if err != nil {
+		return nil, err
+	}
+	return sink.NewDispatcher(cfg.Sinks)
+}
+
+func closeDispatcher(d *sink.Dispatcher) {
+	ctx, cancel := sctx.WithTimeout(sctx.Background(), sinkFlushTimeout)
+	defer cancel()
+	if err := d.Close(ctx); err != nil {
+		logrus.WithError(err).Warn("failed to flush event sinks")
+	}
+}
+
+// ensureEventsServer returns the address of the events server of the state
+// root, starting a detached "runs events-server" if none is listening yet.
+func ensureEventsServer(context *cli.Context) (string, error) {
+	root := context.GlobalString("root")
+	address := shim.EventsAddress(root)
+	if shim.EventsServerListening(address) {
+		return address, nil
+	}
+	// Another runs process may be starting one as well.
+	unlock, err := shim.LockEventsServer(root)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	if shim.EventsServerListening(address) {
+		return address, nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command(self, append(globalArgs(context), "events-server")...)
+	cmd.Dir = "/"
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	if err := cmd.Process.Release(); err != nil {
+		return "", err
+	}
+	for i := 0; i < 50; i++ {
+		if shim.EventsServerListening(address) {
+			return address, nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return "", fmt.Errorf("events server did not start listening on %s", address)
+}
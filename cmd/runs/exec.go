@@ -0,0 +1,325 @@
+package main
+
+import (
+	sctx "context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/console"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/protobuf"
+	"github.com/containerd/containerd/protobuf/types"
+	"github.com/containerd/containerd/runtime"
+	"github.com/kata-contrib/runs/pkg/cio"
+	"github.com/kata-contrib/runs/pkg/shim"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var execCommand = cli.Command{
+	Name:  "exec",
+	Usage: "execute new process inside the container",
+	ArgsUsage: `<container-id> <command> [command options]
+
+Where "<container-id>" is the name for the instance of the container and
+"<command>" is the command to be executed in the container.
+
+EXAMPLE:
+For example, if the container is configured to run the linux ps command the
+following will output a list of processes running in the container:
+
+       # runs exec <container-id> ps`,
+	Description: `The exec command runs a new process in a running container, with the process
+of the container's spec as a template. runs stays attached to the process until
+it exits, then exits with its exit status.
+
+With a terminal, the host terminal is put in raw mode and its size follows the
+terminal of runs. The detach key sequence leaves the process running without
+its IO.`,
+	Flags: append([]cli.Flag{
+		cli.BoolFlag{
+			Name:  "tty, t",
+			Usage: "allocate a pseudo-TTY",
+		},
+		detachKeysFlag,
+		cli.StringFlag{
+			Name:  "cwd",
+			Usage: "current working directory in the container",
+		},
+		cli.StringSliceFlag{
+			Name:  "env, e",
+			Usage: "set environment variables",
+		},
+		cli.StringFlag{
+			Name:  "exec-id",
+			Usage: "id of the process, generated when not set",
+		},
+		logURIFlag,
+		cli.StringFlag{
+			Name:  "record",
+			Usage: "record the session to a file in the asciicast v2 format, see \"runs replay\"",
+		},
+		cli.BoolFlag{
+			Name:  "record-input",
+			Usage: "also record the input of the session",
+		},
+	}, ioFlags...),
+	SkipArgReorder: true,
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 2, minArgs); err != nil {
+			return err
+		}
+		if err := checkIOFlags(context); err != nil {
+			return err
+		}
+		if context.String("record") != "" && (context.Bool("null-io") || context.String("log-uri") != "") {
+			return errors.New("--record cannot be used with --null-io or --log-uri")
+		}
+		id := context.Args().First()
+		root := context.GlobalString("root")
+		state, err := shim.LoadState(root, id)
+		if err != nil {
+			return fmt.Errorf("container %s: %w", id, err)
+		}
+		spec, err := loadSpec(filepath.Join(state.Bundle, specConfig))
+		if err != nil {
+			return err
+		}
+		if spec.Process == nil {
+			return fmt.Errorf("the spec of container %s has no process", id)
+		}
+		process := *spec.Process
+		process.Args = context.Args()[1:]
+		process.Terminal = context.Bool("tty")
+		process.Env = append(process.Env, context.StringSlice("env")...)
+		if cwd := context.String("cwd"); cwd != "" {
+			process.Cwd = cwd
+		}
+		specAny, err := protobuf.MarshalAnyToProto(&process)
+		if err != nil {
+			return err
+		}
+		execID := context.String("exec-id")
+		if execID == "" {
+			execID = newExecID()
+		}
+
+		ctx := namespaces.WithNamespace(sctx.Background(), "default")
+		shimManager, err := shim.NewShimManager(ctx, &shim.ManagerConfig{
+			State: root,
+		})
+		if err != nil {
+			return err
+		}
+		task, err := shim.NewTaskManager(shimManager).Get(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if context.Bool("null-io") {
+			return execUnattached(ctx, task, execID, specAny, cio.NullIO)
+		}
+		if logURI := context.String("log-uri"); logURI != "" {
+			creator, err := logURICreator(logURI, process.Terminal)
+			if err != nil {
+				return err
+			}
+			return execUnattached(ctx, task, execID, specAny, creator)
+		}
+
+		stdinC := &stdinCloser{
+			stdin: os.Stdin,
+		}
+		if file := context.String("stdin-file"); file != "" {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			stdinC.stdin = f
+		}
+		var (
+			stdin io.Reader = stdinC
+			term  console.Console
+		)
+		if context.Bool("no-stdin") {
+			stdin = nil
+		}
+		ioOpts := []cio.Opt{cio.WithFIFODir(fifoDir(context, "default"))}
+		if process.Terminal {
+			ioOpts = append(ioOpts, cio.WithTerminal)
+		}
+		if process.Terminal && forwardStdin(context) {
+			if term, err = setRawTerminal(); err != nil {
+				return err
+			}
+			defer term.Reset()
+			if stdin, err = newDetachReader(stdinC, context.String("detach-keys")); err != nil {
+				return err
+			}
+		}
+		detached := make(chan struct{})
+		if stdin != nil {
+			stdin = &detachWatcher{r: stdin, detached: detached}
+		}
+		var rec *cio.Recorder
+		if path := context.String("record"); path != "" {
+			var closeRec func()
+			if rec, closeRec, err = newRecorder(path, term, context.Bool("record-input")); err != nil {
+				return err
+			}
+			defer closeRec()
+			ioOpts = append(ioOpts, cio.WithRecorder(rec))
+		}
+		i, err := cio.NewCreator(append([]cio.Opt{cio.WithStreams(stdin, os.Stdout, os.Stderr)}, ioOpts...)...)(execID)
+		if err != nil {
+			return err
+		}
+		defer i.Close()
+		cfg := i.Config()
+
+		p, err := task.Exec(ctx, execID, runtime.ExecOpts{
+			Spec: specAny,
+			IO: runtime.IO{
+				Stdin:    cfg.Stdin,
+				Stdout:   cfg.Stdout,
+				Stderr:   cfg.Stderr,
+				Terminal: cfg.Terminal,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		stdinC.closer = func() {
+			if err := p.CloseIO(ctx); err != nil {
+				logrus.WithError(err).Warn("failed to close stdin of process")
+			}
+		}
+		if term != nil {
+			var r resizer = p
+			if rec != nil {
+				r = &recordingResizer{resizer: p, rec: rec}
+			}
+			defer forwardResize(ctx, term, r)()
+		}
+		if err := p.Start(ctx); err != nil {
+			p.Delete(ctx)
+			return err
+		}
+
+		exited := make(chan *runtime.Exit, 1)
+		go func() {
+			exit, err := p.Wait(ctx)
+			if err != nil {
+				logrus.WithError(err).Warn("failed to wait for process")
+			}
+			exited <- exit
+		}()
+		var exit *runtime.Exit
+		select {
+		case <-detached:
+			i.Cancel()
+			return nil
+		case exit = <-exited:
+		}
+		i.Wait()
+		logIOStats(i, execID)
+		if _, err := p.Delete(ctx); err != nil {
+			logrus.WithError(err).Warn("failed to delete process")
+		}
+		return exitStatus(exit, nil)
+	},
+}
+
+// execUnattached runs a process whose output the shim sends to a log URI or
+// nowhere, and waits for it to exit.
+func execUnattached(ctx sctx.Context, task runtime.Task, execID string, spec *types.Any, creator cio.Creator) error {
+	i, err := creator(execID)
+	if err != nil {
+		return err
+	}
+	cfg := i.Config()
+	p, err := task.Exec(ctx, execID, runtime.ExecOpts{
+		Spec: spec,
+		IO: runtime.IO{
+			Stdout:   cfg.Stdout,
+			Stderr:   cfg.Stderr,
+			Terminal: cfg.Terminal,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer p.Delete(ctx)
+	if err := p.Start(ctx); err != nil {
+		return err
+	}
+	return exitStatus(p.Wait(ctx))
+}
+
+// newRecorder starts recording a session to path, with the size of term when
+// the session has one. The returned function ends the recording.
+func newRecorder(path string, term console.Console, input bool) (*cio.Recorder, func(), error) {
+	width, height := uint32(80), uint32(24)
+	if term != nil {
+		if size, err := term.Size(); err == nil && size.Width > 0 && size.Height > 0 {
+			width, height = uint32(size.Width), uint32(size.Height)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+	rec, err := cio.NewRecorder(f, width, height, input)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("failed to record session: %w", err)
+	}
+	return rec, func() {
+		err := rec.Close()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			logrus.WithError(err).Warn("failed to record session")
+		}
+	}, nil
+}
+
+// recordingResizer records the resizes of a session.
+type recordingResizer struct {
+	resizer
+	rec *cio.Recorder
+}
+
+func (r *recordingResizer) ResizePty(ctx sctx.Context, size runtime.ConsoleSize) error {
+	if err := r.rec.Resize(size.Width, size.Height); err != nil {
+		logrus.WithError(err).Debug("failed to record resize")
+	}
+	return r.resizer.ResizePty(ctx, size)
+}
+
+// detachWatcher closes detached when the detach key sequence is read.
+type detachWatcher struct {
+	r        io.Reader
+	detached chan struct{}
+}
+
+func (w *detachWatcher) Read(p []byte) (int, error) {
+	n, err := w.r.Read(p)
+	if errors.Is(err, errDetached) {
+		close(w.detached)
+	}
+	return n, err
+}
+
+func newExecID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "exec-" + hex.EncodeToString(b)
+}
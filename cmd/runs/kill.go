@@ -32,6 +32,7 @@ signal to the init process of the "ubuntu01" container:
 			Name:  "all, a",
 			Usage: "send the specified signal to all processes inside the container",
 		},
+		timeoutFlag,
 	},
 	Action: func(context *cli.Context) error {
 		if err := checkArgs(context, 1, minArgs); err != nil {
@@ -40,6 +41,9 @@ signal to the init process of the "ubuntu01" container:
 		if err := checkArgs(context, 2, maxArgs); err != nil {
 			return err
 		}
+		if err := setupTimeouts(context, containerRuntime(context, context.Args().First())); err != nil {
+			return err
+		}
 
 		ctx := namespaces.WithNamespace(sctx.Background(), "default")
 
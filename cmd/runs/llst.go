@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"text/tabwriter"
 	"time"
@@ -35,6 +36,10 @@ type containerState struct {
 	// Annotations map[string]string `json:"annotations,omitempty"`
 	// The owner of the state directory (the owner of the container).
 	Owner string `json:"owner"`
+	// OOMKilled is set when the container was killed for running out of memory
+	OOMKilled bool `json:"oom_killed"`
+	// OOMEvents counts the OOM events of the container
+	OOMEvents int `json:"oom_events"`
 }
 
 // type containerState struct {
@@ -112,15 +117,16 @@ command(s) that get executed on start, edit the args parameter of the spec. See
 		s, _ := loadStates(context)
 
 		w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-		fmt.Fprint(w, "ID\tPID\tSTATUS\tBUNDLE\tCREATED\tOWNER\n")
+		fmt.Fprint(w, "ID\tPID\tSTATUS\tBUNDLE\tCREATED\tOWNER\tOOM\n")
 		for _, item := range s {
-			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n",
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
 				item.ID,
 				item.InitProcessPid,
 				item.Status,
 				item.Bundle,
 				item.Created.Format(time.RFC3339Nano),
 				item.Owner,
+				oomString(item),
 			)
 
 			if err := w.Flush(); err != nil {
@@ -146,7 +152,8 @@ func loadStates(context *cli.Context) ([]containerState, error) {
 
 	var s []containerState
 	for _, item := range list {
-		if !item.IsDir() {
+		// Dot directories, such as the shim pools, hold no container.
+		if !item.IsDir() || strings.HasPrefix(item.Name(), ".") {
 			continue
 		}
 		st, err := item.Info()
@@ -187,33 +194,55 @@ func loadStates(context *cli.Context) ([]containerState, error) {
 			return nil, err
 		}
 
-		status := ""
-		switch state.Status {
-		case runtime.CreatedStatus:
-			status = "CreatedStatus"
-		case runtime.RunningStatus:
-			status = "RunningStatus"
-		case runtime.StoppedStatus:
-			status = "StoppedStatus"
-		case runtime.DeletedStatus:
-			status = "DeletedStatus"
-		case runtime.PausedStatus:
-			status = "PausedStatus"
-		case runtime.PausingStatus:
-			status = "PausingStatus"
-		default:
-			status = "wrong parameter"
-		}
-
-		s = append(s, containerState{
-			ID:             id,
-			InitProcessPid: state.InitProcessPid,
-			Status:         status,
-			Bundle:         state.Bundle,
-			Created:        state.Created,
-			Owner:          owner.Name,
-		})
+		s = append(s, newContainerState(id, state, owner.Name))
 	}
 
 	return s, nil
 }
+
+func newContainerState(id string, state *shim.State, owner string) containerState {
+	return containerState{
+		ID:             id,
+		InitProcessPid: state.InitProcessPid,
+		Status:         statusString(state.Status),
+		Bundle:         state.Bundle,
+		Created:        state.Created,
+		Owner:          owner,
+		OOMKilled:      state.OOMKilled,
+		OOMEvents:      state.OOMEvents,
+	}
+}
+
+// oomString summarizes the OOM events of a container for list.
+func oomString(s containerState) string {
+	switch {
+	case s.OOMKilled:
+		return "killed"
+	case s.OOMEvents > 0:
+		return fmt.Sprintf("%d events", s.OOMEvents)
+	default:
+		return "-"
+	}
+}
+
+// statusString returns the name of a task status as shown by list.
+func statusString(status runtime.Status) string {
+	switch status {
+	case shim.UnknownStatus:
+		return "unknown"
+	case runtime.CreatedStatus:
+		return "CreatedStatus"
+	case runtime.RunningStatus:
+		return "RunningStatus"
+	case runtime.StoppedStatus:
+		return "StoppedStatus"
+	case runtime.DeletedStatus:
+		return "DeletedStatus"
+	case runtime.PausedStatus:
+		return "PausedStatus"
+	case runtime.PausingStatus:
+		return "PausingStatus"
+	default:
+		return "wrong parameter"
+	}
+}
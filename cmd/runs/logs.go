@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/kata-contrib/runs/pkg/cio"
+	"github.com/kata-contrib/runs/pkg/logdriver"
+	"github.com/kata-contrib/runs/pkg/monitor"
+	"github.com/kata-contrib/runs/pkg/shim"
+	"github.com/kata-contrib/runs/pkg/util"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var logsCommand = cli.Command{
+	Name:  "logs",
+	Usage: "print the logs of a container",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container.`,
+	Description: `The logs command prints the output of a container. A log written in the cri or
+json format (see "runs create --container-log-format") is printed as the output
+of the container, stdout to stdout and stderr to stderr, and can be filtered by
+time.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "shim",
+			Usage: "print the log of the container's shim",
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "print the output since a timestamp (e.g. 2022-06-30T14:52:36Z) or since a duration ago (e.g. 10m)",
+		},
+		cli.BoolFlag{
+			Name:  "timestamps, t",
+			Usage: "print the timestamp of every line",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+		id := context.Args().First()
+		root := context.GlobalString("root")
+		filtered := context.IsSet("since") || context.Bool("timestamps")
+		if context.Bool("shim") {
+			if filtered {
+				return errors.New("--since and --timestamps do not apply to the shim log")
+			}
+			return printRotatedLog(os.Stdout, shim.ShimLogPath(root, id))
+		}
+		path, format, err := containerLog(root, id)
+		if err != nil {
+			return err
+		}
+		if format == cio.LogFormatRaw {
+			if filtered {
+				return fmt.Errorf("the log of container %s has no timestamps, create it with --container-log-format cri or json", id)
+			}
+			return printRotatedLog(os.Stdout, path)
+		}
+		var since time.Time
+		if s := context.String("since"); s != "" {
+			if since, err = parseSince(s, time.Now()); err != nil {
+				return err
+			}
+		}
+		return printRecords(os.Stdout, os.Stderr, path, format, since, context.Bool("timestamps"))
+	},
+}
+
+// containerLog returns the file the output of a container goes to and its
+// format: the file of its log URI, or the log of its monitor.
+func containerLog(root, id string) (string, cio.LogFormat, error) {
+	state, err := shim.LoadState(root, id)
+	if err != nil {
+		return monitor.LogPath(root, id), cio.LogFormatRaw, nil
+	}
+	if state.LogDriver != "" && state.LogDriver != logdriver.File {
+		return "", "", fmt.Errorf("the output of container %s goes to %s, read it there", id, state.LogDriver)
+	}
+	if state.LogURI == "" {
+		format := state.LogFormat
+		if format == "" {
+			format = cio.LogFormatRaw
+		}
+		return monitor.LogPath(root, id), format, nil
+	}
+	u, err := url.Parse(state.LogURI)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid log uri %q: %w", state.LogURI, err)
+	}
+	if u.Scheme != "file" {
+		return "", "", fmt.Errorf("the output of container %s goes to %s, not to a file", id, state.LogURI)
+	}
+	return u.Path, cio.LogFormatRaw, nil
+}
+
+// parseSince parses a timestamp, or a duration before now.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --since %q, expected a timestamp like 2022-06-30T14:52:36Z or a duration like 10m", s)
+	}
+	return t, nil
+}
+
+// printRecords prints the output of a structured log and the files it was
+// rotated into to stdout and stderr, leaving out the records older than since.
+func printRecords(stdout, stderr io.Writer, path string, format cio.LogFormat, since time.Time, timestamps bool) error {
+	files, err := rotatedLogFiles(path)
+	if err != nil {
+		return err
+	}
+	p := &recordPrinter{
+		stdout:     stdout,
+		stderr:     stderr,
+		timestamps: timestamps,
+		midLine:    make(map[string]bool),
+	}
+	for _, file := range files {
+		f, err := util.OpenRotated(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Rotated away while reading.
+				continue
+			}
+			return err
+		}
+		err = p.printFile(f, format, since)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// recordPrinter prints the records of a log as the output of the container.
+type recordPrinter struct {
+	stdout, stderr io.Writer
+	timestamps     bool
+	// midLine tells the streams whose last record ended in a partial line.
+	midLine map[string]bool
+}
+
+func (p *recordPrinter) printFile(r io.Reader, format cio.LogFormat, since time.Time) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			line = line[:len(line)-1]
+			if len(line) > 0 {
+				rec, perr := cio.ParseLogRecord(format, line)
+				if perr != nil {
+					logrus.WithError(perr).Warn("skipping log record")
+				} else if !rec.Time.Before(since) {
+					if err := p.print(rec); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		// A record without newline is still being written.
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *recordPrinter) print(rec *cio.LogRecord) error {
+	w := p.stdout
+	if rec.Stream == cio.StreamStderr {
+		w = p.stderr
+	}
+	var buf []byte
+	if p.timestamps && !p.midLine[rec.Stream] {
+		buf = rec.Time.AppendFormat(buf, time.RFC3339Nano)
+		buf = append(buf, ' ')
+	}
+	buf = append(buf, rec.Line...)
+	if !rec.Partial {
+		buf = append(buf, '\n')
+	}
+	p.midLine[rec.Stream] = rec.Partial
+	_, err := w.Write(buf)
+	return err
+}
+
+// printRotatedLog writes a log and the files it was rotated into to w, oldest
+// first.
+func printRotatedLog(w io.Writer, path string) error {
+	files, err := rotatedLogFiles(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := copyFile(w, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotatedLogFiles returns the files a log was rotated into followed by the
+// log itself, oldest first. Rotated files may be compressed.
+func rotatedLogFiles(path string) ([]string, error) {
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no log found at %s", path)
+		}
+		return nil, err
+	}
+	var files []string
+	for n := 1; ; n++ {
+		name := util.RotatedName(path, n)
+		if _, err := os.Stat(name); err != nil {
+			name = util.CompressedName(name)
+			if _, err := os.Stat(name); err != nil {
+				break
+			}
+		}
+		files = append([]string{name}, files...)
+	}
+	return append(files, path), nil
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := util.OpenRotated(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Rotated away while reading.
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kata-contrib/runs/pkg/cio"
+	"github.com/kata-contrib/runs/pkg/util"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2022, 6, 30, 15, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "10m", want: now.Add(-10 * time.Minute)},
+		{in: "1h30m", want: now.Add(-90 * time.Minute)},
+		{in: "0s", want: now},
+		{in: "2022-06-30T14:52:36Z", want: time.Date(2022, 6, 30, 14, 52, 36, 0, time.UTC)},
+		{in: "2022-06-30T14:52:36.5+02:00", want: time.Date(2022, 6, 30, 12, 52, 36, 500000000, time.UTC)},
+		{in: "2022-06-30", wantErr: true},
+		{in: "yesterday", wantErr: true},
+		{in: "", wantErr: true},
+	} {
+		got, err := parseSince(tc.in, now)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%q: parsed %s, want an error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+		} else if !got.Equal(tc.want) {
+			t.Errorf("%q: got %s, want %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestPrintRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "container.log")
+	// The oldest records were rotated and compressed, the newest record
+	// of the current log is still being written.
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("2022-06-30T14:00:00Z stdout F old\n"))
+	zw.Close()
+	for name, data := range map[string][]byte{
+		util.CompressedName(util.RotatedName(path, 2)): gz.Bytes(),
+		util.RotatedName(path, 1): []byte("2022-06-30T14:10:00Z stdout F before\n" +
+			"2022-06-30T14:20:00Z stderr P warn\n" +
+			"not a record\n"),
+		path: []byte("2022-06-30T14:20:01Z stderr F ing\n" +
+			"2022-06-30T14:20:02Z stdout F last\n" +
+			"2022-06-30T14:20:03Z stdout F still writ"),
+	} {
+		if err := os.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name       string
+		since      time.Time
+		timestamps bool
+		stdout     string
+		stderr     string
+	}{
+		{
+			name:   "all",
+			stdout: "old\nbefore\nlast\n",
+			stderr: "warning\n",
+		},
+		{
+			name:   "since",
+			since:  time.Date(2022, 6, 30, 14, 5, 0, 0, time.UTC),
+			stdout: "before\nlast\n",
+			stderr: "warning\n",
+		},
+		{
+			name:   "since the end of a partial line",
+			since:  time.Date(2022, 6, 30, 14, 20, 1, 0, time.UTC),
+			stdout: "last\n",
+			stderr: "ing\n",
+		},
+		{
+			name:       "timestamps",
+			since:      time.Date(2022, 6, 30, 14, 10, 0, 0, time.UTC),
+			timestamps: true,
+			stdout:     "2022-06-30T14:10:00Z before\n2022-06-30T14:20:02Z last\n",
+			// The timestamp of a line is the one of its first record.
+			stderr: "2022-06-30T14:20:00Z warning\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if err := printRecords(&stdout, &stderr, path, cio.LogFormatCRI, tc.since, tc.timestamps); err != nil {
+				t.Fatal(err)
+			}
+			if stdout.String() != tc.stdout {
+				t.Errorf("stdout %q, want %q", stdout.String(), tc.stdout)
+			}
+			if stderr.String() != tc.stderr {
+				t.Errorf("stderr %q, want %q", stderr.String(), tc.stderr)
+			}
+		})
+	}
+}
+
+func TestPrintRecordsNoLog(t *testing.T) {
+	var out bytes.Buffer
+	if err := printRecords(&out, &out, filepath.Join(t.TempDir(), "container.log"), cio.LogFormatJSON, time.Time{}, false); err == nil {
+		t.Fatal("printed a log that does not exist")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/kata-contrib/runs/pkg/cio"
+	"github.com/urfave/cli"
+)
+
+var logURIFlag = cli.StringFlag{
+	Name:  "log-uri",
+	Usage: "send the output straight from the shim to a log URI, file:///path or binary:///path?key=value",
+}
+
+// logURICreator returns the IO of a process whose output the shim sends to a
+// log URI: a file, a logging binary started by the shim with the query as
+// arguments, or any other scheme the shim knows.
+func logURICreator(uri string, terminal bool) (cio.Creator, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log uri %q: %w", uri, err)
+	}
+	switch u.Scheme {
+	case "file":
+		return cio.LogFile(u.Path), nil
+	case "binary":
+		args := make(map[string]string)
+		for k, vs := range u.Query() {
+			if len(vs) > 0 {
+				args[k] = vs[0]
+			}
+		}
+		if terminal {
+			return cio.TerminalBinaryIO(u.Path, args), nil
+		}
+		return cio.BinaryIO(u.Path, args), nil
+	case "":
+		return nil, fmt.Errorf("log uri %q has no scheme", uri)
+	default:
+		return cio.LogURI(u), nil
+	}
+}
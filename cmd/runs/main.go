@@ -9,7 +9,9 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/kata-contrib/runs/pkg/config"
 	"github.com/opencontainers/runtime-spec/specs-go"
 
 	"github.com/sirupsen/logrus"
@@ -94,21 +96,45 @@ func main() {
 			Value: root,
 			Usage: "root directory for storage of container state (this should be located in tmpfs)",
 		},
+		cli.StringFlag{
+			Name:   "config",
+			Value:  config.DefaultPath,
+			EnvVar: "RUNS_CONFIG",
+			Usage:  "path to the runs configuration file",
+		},
+		cli.DurationFlag{
+			Name:   "shim-load-timeout",
+			Value:  5 * time.Second,
+			EnvVar: "RUNS_SHIM_LOAD_TIMEOUT",
+			Usage:  "timeout for connecting to a running shim",
+		},
+		cli.DurationFlag{
+			Name:   "shim-cleanup-timeout",
+			Value:  5 * time.Second,
+			EnvVar: "RUNS_SHIM_CLEANUP_TIMEOUT",
+			Usage:  "timeout for cleaning up after a dead or failed shim",
+		},
+		cli.DurationFlag{
+			Name:   "shim-shutdown-timeout",
+			Value:  3 * time.Second,
+			EnvVar: "RUNS_SHIM_SHUTDOWN_TIMEOUT",
+			Usage:  "timeout for shutting a shim down",
+		},
 		cli.StringFlag{
 			Name:  "namespace",
 			Usage: "namespace to publish to",
 		},
 		cli.StringFlag{
 			Name:  "address",
-			Usage: "namespace to publish to",
+			Usage: "address events are published to, the state root when it is a directory",
 		},
 		cli.StringFlag{
 			Name:  "publish-binary",
-			Usage: "namespace to publish to",
+			Usage: "binary shims publish events with",
 		},
 		cli.StringFlag{
 			Name:  "id",
-			Usage: "namespace to publish to",
+			Usage: "id of the container the shim serves",
 		},
 		// cli.StringFlag{
 		// 	Name:   "criu",
@@ -126,21 +152,32 @@ func main() {
 		// },
 	}
 	app.Commands = []cli.Command{
+		attachCommand,
+		checkCommand,
 		// checkpointCommand,
 		createCommand,
 		deleteCommand,
-		// eventsCommand,
-		// execCommand,
+		eventsCommand,
+		eventsServerCommand,
+		execCommand,
+		inspectCommand,
 		killCommand,
 		listCommand,
+		logsCommand,
+		monitorCommand,
+		onExitCommand,
 		// pauseCommand,
+		poolCommand,
+		publishCommand,
+		replayCommand,
 		// psCommand,
 		// restoreCommand,
 		// resumeCommand,
-		// runCommand,
+		runCommand,
+		shimLogCommand,
 		specCommand,
 		startCommand,
-		// stateCommand,
+		stateCommand,
 		// updateCommand,
 		// featuresCommand,
 	}
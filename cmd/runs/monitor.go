@@ -0,0 +1,397 @@
+package main
+
+import (
+	sctx "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/runtime"
+	"github.com/kata-contrib/runs/pkg/cio"
+	"github.com/kata-contrib/runs/pkg/logdriver"
+	"github.com/kata-contrib/runs/pkg/monitor"
+	"github.com/kata-contrib/runs/pkg/shim"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// monitorCreateTimeout bounds how long a monitor waits for the task of
+	// its container to be created.
+	monitorCreateTimeout = 2 * time.Minute
+	// monitorDrainTimeout bounds how long a monitor copies output left
+	// behind by a task that exited.
+	monitorDrainTimeout = 5 * time.Second
+	// ioRecordInterval is how often a monitor records the IO counters and
+	// the output dropped by the rate limit of the log.
+	ioRecordInterval = 5 * time.Second
+)
+
+var monitorCommand = cli.Command{
+	Name:  "monitor",
+	Usage: "own the IO of a container for as long as it runs",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container.`,
+	Description: `The monitor is started by create. It creates the FIFOs handed to the shim,
+writes the output of the container to its log, accepts attach connections and
+records the exit status of the task. Once ready, it writes its description to
+file descriptor 3.`,
+	Hidden: true,
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "fifo-dir",
+			Usage: "directory the IO FIFOs are created in",
+		},
+		cli.BoolFlag{
+			Name:  "terminal",
+			Usage: "the container has a terminal",
+		},
+		cli.StringFlag{
+			Name:  "console-socket",
+			Usage: "path to an AF_UNIX socket which will receive the master end of a pseudoterminal bridged to the terminal of the container",
+		},
+		cli.BoolFlag{
+			Name:  "no-stdin",
+			Usage: "the container has no stdin",
+		},
+		cli.StringFlag{
+			Name:  "stdin-file",
+			Usage: "file copied to the stdin of the container",
+		},
+		cli.StringFlag{
+			Name:  "log-name",
+			Usage: "container name the log driver tags the output with, the container id when empty",
+		},
+	}, containerLogFlags...),
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+		id := context.Args().First()
+		root := context.GlobalString("root")
+		config, err := containerLogConfig(context)
+		if err != nil {
+			return err
+		}
+		config.Root = root
+		config.ID = id
+		config.FIFODir = context.String("fifo-dir")
+		config.Terminal = context.Bool("terminal")
+		config.LogPath = monitor.LogPath(root, id)
+		config.ConsoleSocket = context.String("console-socket")
+		config.NoStdin = context.Bool("no-stdin")
+		config.StdinFile = context.String("stdin-file")
+		config.LogName = context.String("log-name")
+
+		ctx, cancel := signal.NotifyContext(sctx.Background(), unix.SIGINT, unix.SIGTERM)
+		defer cancel()
+		ctx = namespaces.WithNamespace(ctx, "default")
+
+		m, err := monitor.New(ctx, config)
+		if err != nil {
+			return err
+		}
+		defer m.Close()
+
+		ready := os.NewFile(3, "ready")
+		err = json.NewEncoder(ready).Encode(m.Info())
+		ready.Close()
+		if err != nil {
+			return err
+		}
+		m.Serve()
+		go recordIO(ctx, m, root, id)
+
+		task, err := loadTask(ctx, root, id)
+		if err != nil || task == nil {
+			return err
+		}
+		m.SetCloseIO(func() error {
+			return task.CloseIO(ctx)
+		})
+		go m.WatchResize(ctx, func(width, height uint32) error {
+			return task.ResizePty(ctx, runtime.ConsoleSize{Width: width, Height: height})
+		})
+		exit, err := task.Wait(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			// The shim is gone, which marks the container unknown.
+			logrus.WithError(err).WithField("id", id).Warn("failed to wait for task")
+		}
+		if exit != nil {
+			err := shim.UpdateState(root, id, func(state *shim.State) error {
+				if state.Status == runtime.StoppedStatus {
+					return nil
+				}
+				state.Status = runtime.StoppedStatus
+				state.ExitStatus = exit.Status
+				state.ExitedAt = exit.Timestamp
+				return nil
+			})
+			if err != nil && !os.IsNotExist(err) {
+				logrus.WithError(err).WithField("id", id).Warn("failed to record exit status")
+			}
+		}
+		m.Drain(monitorDrainTimeout)
+		saveIO(m, root, id, nil)
+		logIOStats(m.IO(), id)
+		return nil
+	},
+}
+
+// containerLogFlags configure the log the monitor writes the output of a
+// container to.
+var containerLogFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "container-log-format",
+		Usage: "format of the container log: raw, cri (timestamp stream P|F message) or json (one {\"log\",\"stream\",\"time\"} object per line)",
+	},
+	cli.StringFlag{
+		Name:  "log-max-size",
+		Value: "10m",
+		Usage: "rotate the container log once it grows past this size (e.g. 512k, 10m), 0 disables rotation",
+	},
+	cli.IntFlag{
+		Name:  "log-max-files",
+		Value: 3,
+		Usage: "number of rotated container logs to keep",
+	},
+	cli.BoolFlag{
+		Name:  "log-compress",
+		Usage: "gzip the rotated container logs",
+	},
+	cli.StringFlag{
+		Name:  "log-rate-limit",
+		Usage: "bytes per second written to the container log (e.g. 1m, at least 32k), output past it is dropped and counted in inspect",
+	},
+	cli.StringFlag{
+		Name:  "log-driver",
+		Usage: "where the output of the container goes: file (the container log), journald or syslog (RFC 5424)",
+	},
+	cli.StringFlag{
+		Name:  "log-driver-address",
+		Usage: "socket of the log driver, defaults to /run/systemd/journal/socket for journald and /dev/log for syslog; syslog also takes unix://, unixgram://, tcp:// and udp:// URLs",
+	},
+}
+
+// containerLogConfig returns the log settings of containerLogFlags.
+func containerLogConfig(context *cli.Context) (monitor.Config, error) {
+	format, err := cio.ParseLogFormat(context.String("container-log-format"))
+	if err != nil {
+		return monitor.Config{}, err
+	}
+	maxSize, err := parseSize(context.String("log-max-size"))
+	if err != nil {
+		return monitor.Config{}, err
+	}
+	rate, err := parseSize(context.String("log-rate-limit"))
+	if err != nil {
+		return monitor.Config{}, err
+	}
+	if rate > 0 && rate < monitor.MinLogRateLimit {
+		return monitor.Config{}, fmt.Errorf("--log-rate-limit must be at least %d bytes per second, the size of a chunk of output", monitor.MinLogRateLimit)
+	}
+	driver, err := logdriver.ParseName(context.String("log-driver"))
+	if err != nil {
+		return monitor.Config{}, err
+	}
+	address := context.String("log-driver-address")
+	if driver == logdriver.File {
+		if address != "" {
+			return monitor.Config{}, errors.New("--log-driver-address needs --log-driver journald or syslog")
+		}
+	} else if format != cio.LogFormatRaw {
+		return monitor.Config{}, fmt.Errorf("--container-log-format applies to the container log, not to the %s log driver", driver)
+	}
+	return monitor.Config{
+		LogFormat:        format,
+		LogMaxSize:       maxSize,
+		LogMaxFiles:      context.Int("log-max-files"),
+		LogCompress:      context.Bool("log-compress"),
+		LogRateLimit:     rate,
+		LogDriver:        driver,
+		LogDriverAddress: address,
+	}, nil
+}
+
+// containerLogArgs returns the flags passing the log settings on to a
+// monitor.
+func containerLogArgs(context *cli.Context) []string {
+	var args []string
+	for _, name := range []string{"container-log-format", "log-max-size", "log-rate-limit", "log-driver", "log-driver-address"} {
+		if v := context.String(name); v != "" {
+			args = append(args, "--"+name, v)
+		}
+	}
+	args = append(args, "--log-max-files", strconv.Itoa(context.Int("log-max-files")))
+	if context.Bool("log-compress") {
+		args = append(args, "--log-compress")
+	}
+	return args
+}
+
+// ioRecord is what a monitor records about the IO of a container.
+type ioRecord struct {
+	dropped shim.LogDropped
+	stats   cio.Stats
+	err     string
+}
+
+// recordIO saves the IO counters, the IO error and the output dropped by the
+// rate limit of the log in the state every ioRecordInterval, until ctx is
+// done.
+func recordIO(ctx sctx.Context, m *monitor.Monitor, root, id string) {
+	ticker := time.NewTicker(ioRecordInterval)
+	defer ticker.Stop()
+	var last ioRecord
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			saveIO(m, root, id, &last)
+		}
+	}
+}
+
+// saveIO records the IO of a container in the state, unless it did not
+// change since last.
+func saveIO(m *monitor.Monitor, root, id string, last *ioRecord) {
+	var rec ioRecord
+	rec.dropped.Stdout, rec.dropped.Stderr = m.Dropped()
+	rec.stats = m.IO().Stats()
+	if err := m.IO().Err(); err != nil {
+		rec.err = err.Error()
+	}
+	if rec == (ioRecord{}) || (last != nil && rec == *last) {
+		return
+	}
+	err := shim.UpdateState(root, id, func(state *shim.State) error {
+		if rec.dropped != (shim.LogDropped{}) {
+			state.LogDropped = &rec.dropped
+		}
+		state.IOStats = &rec.stats
+		state.IOError = rec.err
+		return nil
+	})
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.WithError(err).WithField("id", id).Warn("failed to record IO")
+		}
+		return
+	}
+	if last != nil {
+		*last = rec
+	}
+}
+
+// loadTask waits for the task of a container to be created and connects to it.
+// It returns no task when the container goes away first.
+func loadTask(ctx sctx.Context, root, id string) (runtime.Task, error) {
+	deadline := time.Now().Add(monitorCreateTimeout)
+	for {
+		if _, err := shim.LoadState(root, id); err == nil {
+			break
+		}
+		if _, err := os.Stat(filepath.Join(root, id)); os.IsNotExist(err) {
+			return nil, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("task %s was not created within %s", id, monitorCreateTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	shimManager, err := shim.NewShimManager(ctx, &shim.ManagerConfig{
+		State: root,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shim.NewTaskManager(shimManager).Get(ctx, id)
+}
+
+// startMonitor starts the monitor of a container and returns its description
+// once it is ready. The log driver tags the output with name.
+func startMonitor(context *cli.Context, id, name string, terminal bool) (*monitor.Info, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	args := append(globalArgs(context), "monitor")
+	dir, err := filepath.Abs(fifoDir(context, "default"))
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, "--fifo-dir", dir)
+	if context.Bool("no-stdin") {
+		args = append(args, "--no-stdin")
+	}
+	if file := context.String("stdin-file"); file != "" {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--stdin-file", abs)
+	}
+	if terminal {
+		args = append(args, "--terminal")
+	}
+	args = append(args, containerLogArgs(context)...)
+	if name != "" && name != id {
+		args = append(args, "--log-name", name)
+	}
+	if socket := context.String("console-socket"); socket != "" {
+		if !terminal {
+			return nil, errors.New("cannot use console socket if the container has no terminal")
+		}
+		abs, err := filepath.Abs(socket)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--console-socket", abs)
+	}
+	args = append(args, id)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	cmd := exec.Command(self, args...)
+	cmd.Dir = "/"
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var info monitor.Info
+	if err := json.NewDecoder(r).Decode(&info); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, errors.New("monitor exited before it was ready, see the runs log")
+	}
+	if err := cmd.Process.Release(); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	sctx "context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/runtime"
+	"github.com/kata-contrib/runs/pkg/shim"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	// onExitTimeout bounds how long the on-exit command of a container may
+	// run.
+	onExitTimeout = time.Minute
+	// onExitReconnectTimeout bounds how long an unreachable shim is retried
+	// before its container is taken for gone.
+	onExitReconnectTimeout = 30 * time.Second
+	// onExitMaxDelay caps the delay between attempts to reach the shim.
+	onExitMaxDelay = 5 * time.Second
+)
+
+var onExitFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "rm",
+		Usage: "delete the task, the shim and the state of the container once it exits",
+	},
+	cli.StringFlag{
+		Name:  "on-exit",
+		Usage: "host command run once the container exits, with RUNS_CONTAINER_ID, RUNS_EXIT_STATUS (unknown when the shim was lost) and the state JSON on stdin",
+	},
+}
+
+var onExitCommand = cli.Command{
+	Name:  "on-exit",
+	Usage: "wait for a container to exit and take its on-exit actions",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container.`,
+	Hidden: true,
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+		id := context.Args().First()
+		ctx := namespaces.WithNamespace(sctx.Background(), "default")
+		shimManager, err := shim.NewShimManager(ctx, &shim.ManagerConfig{
+			State: context.GlobalString("root"),
+		})
+		if err != nil {
+			return err
+		}
+		taskManager := shim.NewTaskManager(shimManager)
+		exit, err := waitExit(ctx, taskManager, context.GlobalString("root"), id)
+		if err != nil {
+			return err
+		}
+		return handleExit(ctx, context, taskManager, id, exit)
+	},
+}
+
+// waitExit waits for the task of a container to exit. A failed wait only
+// counts as an exit once the task reports it stopped, or once its shim stayed
+// unreachable for onExitReconnectTimeout: the container is gone with it, with
+// an unknown exit, returned as nil.
+func waitExit(ctx sctx.Context, taskManager *shim.TaskManager, root, id string) (*runtime.Exit, error) {
+	delay := 100 * time.Millisecond
+	deadline := time.Now().Add(onExitReconnectTimeout)
+	for {
+		task, err := taskManager.Get(ctx, id)
+		if err == nil {
+			exit, werr := task.Wait(ctx)
+			if werr == nil {
+				return exit, nil
+			}
+			state, serr := task.State(ctx)
+			if serr == nil {
+				if state.Status == runtime.StoppedStatus {
+					return &runtime.Exit{Pid: state.Pid, Status: state.ExitStatus, Timestamp: state.ExitedAt}, nil
+				}
+				// The shim answers for a task that still runs, wait
+				// again.
+				logrus.WithError(werr).WithField("id", id).Debug("failed to wait for task, retrying")
+				delay = 100 * time.Millisecond
+				deadline = time.Now().Add(onExitReconnectTimeout)
+			} else {
+				err = serr
+			}
+		}
+		if err != nil {
+			// The events server may have recorded the exit already.
+			if state, lerr := shim.LoadState(root, id); lerr == nil && state.Status == runtime.StoppedStatus {
+				return &runtime.Exit{Status: state.ExitStatus, Timestamp: state.ExitedAt}, nil
+			} else if os.IsNotExist(lerr) {
+				// Deleted in the meantime, nothing is left to act on.
+				return nil, nil
+			}
+			if time.Now().After(deadline) {
+				logrus.WithError(err).WithField("id", id).Warn("lost track of container, taking its on-exit actions")
+				return nil, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > onExitMaxDelay {
+			delay = onExitMaxDelay
+		}
+	}
+}
+
+// setOnExit records the on-exit actions requested for a container and starts
+// watching it for its exit.
+func setOnExit(context *cli.Context, id string) error {
+	if !context.Bool("rm") && context.String("on-exit") == "" {
+		return nil
+	}
+	command := context.String("on-exit")
+	if command != "" {
+		abs, err := filepath.Abs(command)
+		if err != nil {
+			return err
+		}
+		command = abs
+	}
+	err := shim.UpdateState(context.GlobalString("root"), id, func(state *shim.State) error {
+		state.OnExit = &shim.OnExit{
+			Remove:  context.Bool("rm"),
+			Command: command,
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record on-exit actions: %w", err)
+	}
+	return startOnExit(context, id)
+}
+
+// startOnExit starts a detached "runs on-exit" process for a container.
+func startOnExit(context *cli.Context, id string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(self, append(globalArgs(context), "on-exit", id)...)
+	cmd.Dir = "/"
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// notifyExit hands the exit of a container seen in an event to a "runs
+// on-exit" process, if the container has on-exit actions left to take.
+func notifyExit(context *cli.Context, entry *shim.JournalEntry) {
+	if entry.Topic != runtime.TaskExitEventTopic {
+		return
+	}
+	state, err := shim.LoadState(publishRoot(context), entry.ID)
+	if err != nil || state.OnExit == nil || state.OnExit.Handled || state.Status != runtime.StoppedStatus {
+		return
+	}
+	if err := startOnExit(context, entry.ID); err != nil {
+		logrus.WithError(err).WithField("id", entry.ID).Warn("failed to start on-exit actions")
+	}
+}
+
+// handleExit takes the on-exit actions of a container, unless another runs
+// process claimed them already.
+func handleExit(ctx sctx.Context, context *cli.Context, taskManager *shim.TaskManager, id string, exit *runtime.Exit) error {
+	root := context.GlobalString("root")
+	state, err := shim.ClaimOnExit(root, id, exit)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if state == nil {
+		return nil
+	}
+
+	var result error
+	if state.OnExit.Command != "" {
+		if err := runOnExitCommand(state.OnExit.Command, id, state); err != nil {
+			result = err
+		}
+	}
+	if state.OnExit.Remove {
+		if err := removeContainer(ctx, taskManager, root, id, state); err != nil {
+			result = err
+		}
+	}
+	return result
+}
+
+func runOnExitCommand(command, id string, state *shim.State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := sctx.WithTimeout(sctx.Background(), onExitTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command)
+	cmd.Dir = "/"
+	cmd.Stdin = bytes.NewReader(data)
+	// The exit status is only known once recorded, a lost shim takes it
+	// away.
+	status := "unknown"
+	if state.Status == runtime.StoppedStatus {
+		status = strconv.FormatUint(uint64(state.ExitStatus), 10)
+	}
+	cmd.Env = append(os.Environ(),
+		"RUNS_CONTAINER_ID="+id,
+		"RUNS_EXIT_STATUS="+status,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("on-exit command %s: %s: %w", command, bytes.TrimSpace(out), err)
+	}
+	return nil
+}
+
+// removeContainer deletes the task and the shim of an exited container, if
+// the shim is still around, and removes its bundle files and state.
+func removeContainer(ctx sctx.Context, taskManager *shim.TaskManager, root, id string, state *shim.State) error {
+	if _, err := taskManager.Get(ctx, id); err == nil {
+		if _, err := taskManager.Delete(ctx, id); err != nil {
+			logrus.WithError(err).WithField("id", id).Warn("failed to delete task")
+		}
+	}
+	if err := shim.RemoveBundleFiles(state.Bundle); err != nil {
+		logrus.WithError(err).WithField("id", id).Warn("failed to clean up bundle")
+	}
+	if err := removeFIFODir(state); err != nil {
+		logrus.WithError(err).WithField("id", id).Warn("failed to remove FIFO directory")
+	}
+	return os.RemoveAll(filepath.Join(root, id))
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	sctx "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/protobuf"
+	"github.com/kata-contrib/runs/pkg/config"
+	"github.com/kata-contrib/runs/pkg/shim"
+	"github.com/urfave/cli"
+)
+
+var poolCommand = cli.Command{
+	Name:  "pool",
+	Usage: "manage the pools of pre-started shims",
+	Description: `Runtime handlers with a "pool" section in the config keep idle shims started
+ahead of time. The create command binds one of them to the new container
+instead of starting a shim, and starts a replacement when "refill" is set.`,
+	Subcommands: []cli.Command{
+		poolStatusCommand,
+		poolFillCommand,
+		poolDrainCommand,
+	},
+}
+
+var poolStatusCommand = cli.Command{
+	Name:      "status",
+	Usage:     "list the idle shims of the pools",
+	ArgsUsage: `[handler...]`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format, f",
+			Value: "table",
+			Usage: `select one of: table or json`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		pools, err := selectedPools(context)
+		if err != nil {
+			return err
+		}
+		// Listing starts no shims, the manager needs no addresses.
+		shimManager, err := shim.NewShimManager(sctx.Background(), &shim.ManagerConfig{
+			State: context.GlobalString("root"),
+		})
+		if err != nil {
+			return err
+		}
+
+		type poolStatus struct {
+			Handler string             `json:"handler"`
+			Runtime string             `json:"runtime"`
+			Size    int                `json:"size"`
+			IdleTTL string             `json:"idle_ttl,omitempty"`
+			Refill  bool               `json:"refill"`
+			Shims   []*shim.PooledShim `json:"shims"`
+		}
+		var status []poolStatus
+		for _, p := range pools {
+			shims, err := shimManager.PoolStatus(p.Name)
+			if err != nil {
+				return err
+			}
+			s := poolStatus{
+				Handler: p.Name,
+				Runtime: p.Runtime,
+				Size:    p.Size,
+				Refill:  p.Refill,
+				Shims:   shims,
+			}
+			if p.IdleTTL > 0 {
+				s.IdleTTL = p.IdleTTL.String()
+			}
+			status = append(status, s)
+		}
+
+		switch context.String("format") {
+		case "table":
+			w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+			fmt.Fprint(w, "HANDLER\tSHIM\tSTATE\tAGE\tSTARTUP\n")
+			for _, s := range status {
+				if len(s.Shims) == 0 {
+					fmt.Fprintf(w, "%s\t-\tempty (size %d)\t\t\n", s.Handler, s.Size)
+				}
+				for _, p := range s.Shims {
+					state := "idle"
+					if p.Expired(pools[s.Handler].IdleTTL) {
+						state = "expired"
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+						s.Handler,
+						p.ID,
+						state,
+						time.Since(p.Created).Round(time.Second),
+						p.Startup.Round(time.Millisecond))
+				}
+			}
+			return w.Flush()
+		case "json":
+			return json.NewEncoder(os.Stdout).Encode(status)
+		default:
+			return errors.New("invalid format option")
+		}
+	},
+}
+
+var poolFillCommand = cli.Command{
+	Name:      "fill",
+	Usage:     "start shims until the pools are full, replacing expired ones",
+	ArgsUsage: `[handler...]`,
+	Flags:     append([]cli.Flag{timeoutFlag}, shimLogFlags...),
+	Action: func(context *cli.Context) error {
+		pools, err := selectedPools(context)
+		if err != nil {
+			return err
+		}
+		ctx := namespaces.WithNamespace(sctx.Background(), "default")
+		shimManager, err := poolManager(ctx, context)
+		if err != nil {
+			return err
+		}
+		for _, p := range pools {
+			if err := setupTimeouts(context, p.Name); err != nil {
+				return err
+			}
+			if err := shimManager.FillPool(ctx, p); err != nil {
+				return fmt.Errorf("pool %s: %w", p.Name, err)
+			}
+		}
+		return nil
+	},
+}
+
+var poolDrainCommand = cli.Command{
+	Name:      "drain",
+	Usage:     "stop the idle shims of the pools",
+	ArgsUsage: `[handler...]`,
+	Flags:     []cli.Flag{timeoutFlag},
+	Action: func(context *cli.Context) error {
+		pools, err := selectedPools(context)
+		if err != nil {
+			return err
+		}
+		ctx := namespaces.WithNamespace(sctx.Background(), "default")
+		shimManager, err := poolManager(ctx, context)
+		if err != nil {
+			return err
+		}
+		for _, p := range pools {
+			if err := setupTimeouts(context, p.Name); err != nil {
+				return err
+			}
+			if err := shimManager.DrainPool(ctx, p.Name); err != nil {
+				return fmt.Errorf("pool %s: %w", p.Name, err)
+			}
+		}
+		return nil
+	},
+}
+
+// poolManager returns a shim manager for the pools under the state root.
+func poolManager(ctx sctx.Context, context *cli.Context) (*shim.ShimManager, error) {
+	shimLogger, err := newShimLogger(context)
+	if err != nil {
+		return nil, err
+	}
+	eventsAddress, err := ensureEventsServer(context)
+	if err != nil {
+		return nil, err
+	}
+	return shim.NewShimManager(ctx, &shim.ManagerConfig{
+		State:        context.GlobalString("root"),
+		Address:      publishAddress(context),
+		TTRPCAddress: eventsAddress,
+		ShimLogger:   shimLogger,
+	})
+}
+
+// selectedPools returns the pools of the runtime handlers given as arguments,
+// or of all handlers with a pool when none are given.
+func selectedPools(context *cli.Context) (map[string]shim.PoolConfig, error) {
+	cfg, err := loadConfig(context)
+	if err != nil {
+		return nil, err
+	}
+	names := context.Args()
+	if len(names) == 0 {
+		for _, name := range sortedKeys(cfg.Runtimes) {
+			if cfg.Runtimes[name].Pool.Size > 0 {
+				names = append(names, name)
+			}
+		}
+	}
+	pools := make(map[string]shim.PoolConfig, len(names))
+	for _, name := range names {
+		r, ok := cfg.Runtimes[name]
+		if !ok {
+			return nil, fmt.Errorf("runtime handler %q is not configured", name)
+		}
+		if r.Pool.Size <= 0 {
+			return nil, fmt.Errorf("runtime handler %q has no pool", name)
+		}
+		p, err := handlerPool(name, r)
+		if err != nil {
+			return nil, err
+		}
+		pools[name] = p
+	}
+	return pools, nil
+}
+
+// handlerPool returns the pool of a runtime handler. Its shims are started
+// with the handler's options file.
+func handlerPool(name string, r config.Runtime) (shim.PoolConfig, error) {
+	p := shim.PoolConfig{
+		Name:    name,
+		Runtime: r.Type,
+		Size:    r.Pool.Size,
+		IdleTTL: time.Duration(r.Pool.IdleTTL),
+		Refill:  r.Pool.Refill,
+	}
+	opts, err := shim.NewRuntimeOptions(r.Type, r.Options, nil)
+	if err != nil {
+		return p, err
+	}
+	if opts != nil {
+		if p.Options, err = protobuf.MarshalAnyToProto(opts); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// refillPool refills the pool of a runtime handler in a detached "runs pool
+// fill" process, so that the caller does not wait for the new shim.
+func refillPool(context *cli.Context, name string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := append(globalArgs(context), "pool", "fill")
+	for _, f := range []string{"shim-log-max-size", "shim-log-max-files"} {
+		args = append(args, "--"+f, context.String(f))
+	}
+	if context.Bool("shim-log-forward") {
+		args = append(args, "--shim-log-forward")
+	}
+	args = append(args, name)
+
+	cmd := exec.Command(self, args...)
+	cmd.Dir = "/"
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
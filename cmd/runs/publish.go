@@ -0,0 +1,131 @@
+package main
+
+import (
+	sctx "context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	eventsapi "github.com/containerd/containerd/api/services/ttrpc/events/v1"
+	"github.com/containerd/ttrpc"
+	"github.com/kata-contrib/runs/pkg/shim"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// forwardTimeout bounds handing an event to the events server.
+const forwardTimeout = 5 * time.Second
+
+var publishCommand = cli.Command{
+	Name:  "publish",
+	Usage: "record an event published by a shim",
+	Description: `Shims are started with runs as their publish binary and call
+
+    runs --address <address> publish --topic <topic> --namespace <namespace>
+
+with the protobuf encoded event on stdin, the way they call "containerd
+publish". Task exit, OOM and start events update the state and the journal of
+their container. The address is used as the state root when it is a directory.
+
+The event is handed to the events server of the state root, which also
+delivers it to the event sinks, so that the shim is not kept waiting. Without
+an events server the event is only recorded.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "topic",
+			Usage: "topic of the event",
+		},
+		cli.StringFlag{
+			Name:  "namespace",
+			Usage: "namespace of the event",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 0, exactArgs); err != nil {
+			return err
+		}
+		topic := context.String("topic")
+		if topic == "" {
+			return errors.New("topic required to publish event")
+		}
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read event: %w", err)
+		}
+		var event anypb.Any
+		if err := proto.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+		root := publishRoot(context)
+		env := &eventsapi.Envelope{
+			Timestamp: timestamppb.Now(),
+			Namespace: context.String("namespace"),
+			Topic:     topic,
+			Event:     &event,
+		}
+		if err := forwardEvent(root, env); err == nil {
+			return nil
+		} else if !errors.Is(err, errNoEventsServer) {
+			logrus.WithError(err).Warn("failed to hand event to the events server, recording it")
+		}
+
+		entry, err := shim.RecordEvent(root, env)
+		if err != nil {
+			return err
+		}
+		if entry != nil {
+			notifyExit(context, entry)
+		}
+		return nil
+	},
+}
+
+var errNoEventsServer = errors.New("no events server")
+
+// forwardEvent hands an event to the events server of the state root, which
+// records it and delivers it to the sinks.
+func forwardEvent(root string, env *eventsapi.Envelope) error {
+	conn, err := net.DialTimeout("unix", shim.EventsAddress(root), forwardTimeout)
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.ECONNREFUSED) {
+			return errNoEventsServer
+		}
+		return err
+	}
+	client := ttrpc.NewClient(conn)
+	defer client.Close()
+
+	ctx, cancel := sctx.WithTimeout(sctx.Background(), forwardTimeout)
+	defer cancel()
+	_, err = eventsapi.NewEventsClient(client).Forward(ctx, &eventsapi.ForwardRequest{Envelope: env})
+	return err
+}
+
+// publishAddress returns the address handed to shims, which pass it back to
+// their publish binary, runs itself: the absolute path of the state root.
+func publishAddress(context *cli.Context) string {
+	root := context.GlobalString("root")
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	return root
+}
+
+// publishRoot returns the state root events are published to: the global
+// --address when it names a directory, the global --root otherwise.
+func publishRoot(context *cli.Context) string {
+	if address := context.GlobalString("address"); address != "" {
+		if st, err := os.Stat(address); err == nil && st.IsDir() {
+			return address
+		}
+	}
+	return context.GlobalString("root")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/kata-contrib/runs/pkg/cio"
+	"github.com/urfave/cli"
+)
+
+var replayCommand = cli.Command{
+	Name:  "replay",
+	Usage: "play back a session recorded by exec --record",
+	ArgsUsage: `<file>
+
+Where "<file>" is a recording in the asciicast v2 format.`,
+	Description: `The replay command writes the output of a recorded session to stdout with the
+timing it was recorded with, or faster with --speed. Input and resize events
+are skipped.`,
+	Flags: []cli.Flag{
+		cli.Float64Flag{
+			Name:  "speed, s",
+			Value: 1,
+			Usage: "playback speed, 2 plays twice as fast",
+		},
+		cli.DurationFlag{
+			Name:  "max-wait",
+			Usage: "cap the pauses between events to this duration (e.g. 2s), 0 keeps them",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+		speed := context.Float64("speed")
+		if speed <= 0 {
+			return fmt.Errorf("invalid speed %v", speed)
+		}
+		f, err := os.Open(context.Args().First())
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return replay(os.Stdout, f, speed, context.Duration("max-wait"))
+	},
+}
+
+// replay writes the output events of a recording to w, pausing between them
+// as recorded divided by speed.
+func replay(w io.Writer, r io.Reader, speed float64, maxWait time.Duration) error {
+	rec, err := cio.NewAsciicastReader(r)
+	if err != nil {
+		return err
+	}
+	var last float64
+	for {
+		e, err := rec.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if e.Type != cio.AsciicastOutput {
+			continue
+		}
+		wait := time.Duration((e.Time - last) / speed * float64(time.Second))
+		last = e.Time
+		if maxWait > 0 && wait > maxWait {
+			wait = maxWait
+		}
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+		if _, err := io.WriteString(w, e.Data); err != nil {
+			return err
+		}
+	}
+}
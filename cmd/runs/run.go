@@ -0,0 +1,143 @@
+package main
+
+import (
+	sctx "context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/runtime"
+	"github.com/kata-contrib/runs/pkg/monitor"
+	"github.com/kata-contrib/runs/pkg/shim"
+	"github.com/urfave/cli"
+)
+
+var runCommand = cli.Command{
+	Name:  "run",
+	Usage: "create and run a container",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is your name for the instance of the container that you
+are starting. The name you provide for the container instance must be unique on
+your host.`,
+	Description: `The run command creates an instance of a container for the bundle in the
+current directory and starts it. Unless detached, runs stays attached to the
+container until it exits, then exits with its exit status.
+
+With a terminal, the host terminal is put in raw mode and its size follows the
+terminal of runs. The detach key sequence leaves the container running, "runs
+attach" attaches to it again. With --log-uri the output goes to the log URI and
+runs only waits for the container to exit, as it does with --null-io.`,
+	Flags: append([]cli.Flag{
+		cli.BoolFlag{
+			Name:  "detach, d",
+			Usage: "detach from the container's process",
+		},
+		cli.BoolFlag{
+			Name:  "tty, t",
+			Usage: "allocate a pseudo-TTY for the container's process",
+		},
+		detachKeysFlag,
+	}, createCommand.Flags...),
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+		id := context.Args().First()
+		detachKeys := context.String("detach-keys")
+		if _, err := parseDetachKeys(detachKeys); err != nil {
+			return err
+		}
+		if err := createContainer(context); err != nil {
+			return err
+		}
+
+		root := context.GlobalString("root")
+		ctx := namespaces.WithNamespace(sctx.Background(), "default")
+		shimManager, err := shim.NewShimManager(ctx, &shim.ManagerConfig{
+			State: root,
+		})
+		if err != nil {
+			return err
+		}
+		task, err := shim.NewTaskManager(shimManager).Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if context.Bool("detach") {
+			return task.Start(ctx)
+		}
+		if context.String("log-uri") != "" || context.Bool("null-io") {
+			// The output goes to the log URI or nowhere, there is nothing
+			// to attach to.
+			if err := task.Start(ctx); err != nil {
+				return err
+			}
+			return exitStatus(task.Wait(ctx))
+		}
+
+		info, err := monitor.LoadInfo(root, id)
+		if err != nil {
+			return fmt.Errorf("failed to find monitor: %w", err)
+		}
+		var stdin io.Reader
+		if !forwardStdin(context) {
+			// The container has no stdin, or reads it from a file.
+		} else if !info.Terminal {
+			stdin = os.Stdin
+		} else {
+			c, err := setRawTerminal()
+			if err != nil {
+				return err
+			}
+			defer c.Reset()
+			if stdin, err = newDetachReader(c, detachKeys); err != nil {
+				return err
+			}
+			defer forwardResize(ctx, c, task)()
+		}
+
+		// Attach before starting so that no output is missed.
+		mode := monitor.WantStdin
+		if stdin == nil {
+			mode = monitor.NoStdin
+		}
+		conn, err := monitor.Dial(ctx, info.Socket, mode)
+		if err != nil {
+			return err
+		}
+		if err := task.Start(ctx); err != nil {
+			return err
+		}
+		type waitResult struct {
+			exit *runtime.Exit
+			err  error
+		}
+		exited := make(chan waitResult, 1)
+		go func() {
+			exit, err := task.Wait(ctx)
+			exited <- waitResult{exit, err}
+		}()
+		if err := conn.Copy(ctx, stdin, os.Stdout, os.Stderr); err != nil {
+			if errors.Is(err, errDetached) {
+				return nil
+			}
+			return err
+		}
+		result := <-exited
+		return exitStatus(result.exit, result.err)
+	},
+}
+
+// exitStatus turns the exit of a process into the exit status of runs.
+func exitStatus(exit *runtime.Exit, err error) error {
+	if err != nil {
+		return err
+	}
+	if exit != nil && exit.Status != 0 {
+		return cli.NewExitError("", int(exit.Status))
+	}
+	return nil
+}
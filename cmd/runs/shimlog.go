@@ -0,0 +1,133 @@
+package main
+
+import (
+	sctx "context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/kata-contrib/runs/pkg/shim"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+var shimLogFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "shim-log-max-size",
+		Value: "10m",
+		Usage: "rotate the shim log once it grows past this size (e.g. 512k, 10m), 0 disables rotation",
+	},
+	cli.IntFlag{
+		Name:  "shim-log-max-files",
+		Value: 3,
+		Usage: "number of rotated shim logs to keep",
+	},
+	cli.BoolFlag{
+		Name:  "shim-log-forward",
+		Usage: "also forward the shim log to the runs log, tagged with the container id",
+	},
+}
+
+var shimLogCommand = cli.Command{
+	Name:      "shim-log",
+	Usage:     "persist the logs of a container's shim",
+	ArgsUsage: `<container-id> <bundle> <log-path>`,
+	Hidden:    true,
+	Flags:     shimLogFlags,
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 3, exactArgs); err != nil {
+			return err
+		}
+		config, err := shimLogConfig(context)
+		if err != nil {
+			return err
+		}
+		ctx := namespaces.WithNamespace(sctx.Background(), "default")
+		bundle := &shim.Bundle{
+			ID:        context.Args().Get(0),
+			Path:      context.Args().Get(1),
+			Namespace: "default",
+		}
+		return shim.ServeShimLog(ctx, bundle, context.Args().Get(2), config)
+	},
+}
+
+func shimLogConfig(context *cli.Context) (shim.ShimLogConfig, error) {
+	maxSize, err := parseSize(context.String("shim-log-max-size"))
+	if err != nil {
+		return shim.ShimLogConfig{}, err
+	}
+	return shim.ShimLogConfig{
+		MaxSize:  maxSize,
+		MaxFiles: context.Int("shim-log-max-files"),
+		Forward:  context.Bool("shim-log-forward"),
+	}, nil
+}
+
+// newShimLogger returns a shim logger that persists the logs of each started
+// shim through a detached "runs shim-log" process, so that they are kept after
+// this runs process exits.
+func newShimLogger(context *cli.Context) (shim.ShimLogger, error) {
+	config, err := shimLogConfig(context)
+	if err != nil {
+		return nil, err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return func(_ sctx.Context, bundle *shim.Bundle, path string) (func(), error) {
+		args := append(globalArgs(context), "shim-log",
+			"--shim-log-max-size", strconv.FormatInt(config.MaxSize, 10),
+			"--shim-log-max-files", strconv.Itoa(config.MaxFiles),
+		)
+		if config.Forward {
+			args = append(args, "--shim-log-forward")
+		}
+		args = append(args, bundle.ID, bundle.Path, path)
+
+		cmd := exec.Command(self, args...)
+		cmd.Dir = "/"
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		pid := cmd.Process.Pid
+		if err := cmd.Process.Release(); err != nil {
+			return nil, err
+		}
+		return func() {
+			_ = unix.Kill(pid, unix.SIGKILL)
+		}, nil
+	}, nil
+}
+
+// globalArgs returns the global options of this invocation so that they can
+// be passed on to a runs process started on its behalf.
+func globalArgs(context *cli.Context) []string {
+	root := publishRoot(context)
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	args := []string{"--root", root}
+	if context.GlobalIsSet("config") {
+		config := context.GlobalString("config")
+		if abs, err := filepath.Abs(config); err == nil {
+			config = abs
+		}
+		args = append(args, "--config", config)
+	}
+	if context.GlobalBool("debug") {
+		args = append(args, "--debug")
+	}
+	if l := context.GlobalString("log"); l != "" {
+		args = append(args, "--log", l)
+	}
+	if f := context.GlobalString("log-format"); f != "" {
+		args = append(args, "--log-format", f)
+	}
+	return args
+}
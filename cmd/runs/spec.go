@@ -25,10 +25,11 @@ work for containers that do not include the "sh" program.
 
 EXAMPLE:
   To run docker's hello-world container one needs to set the args parameter
-in the spec to call hello. This can be done using the sed command or a text
-editor. The following commands create a bundle for hello-world, change the
-default args parameter in the spec from "sh" to "/hello", then run the hello
-command in a new hello-world container named container1:
+in the spec to call hello. This can be done with --args, or using the sed
+command or a text editor. The following commands create a bundle for
+hello-world, generate a spec whose args parameter is "/hello" instead of the
+default "sh", then run the hello command in a new hello-world container named
+container1:
 
     mkdir hello
     cd hello
@@ -36,9 +37,14 @@ command in a new hello-world container named container1:
     docker export $(docker create hello-world) > hello-world.tar
     mkdir rootfs
     tar -C rootfs -xf hello-world.tar
-    runc spec
-    sed -i 's;"sh";"/hello";' ` + specConfig + `
-    runc run container1
+    runs spec --args /hello
+    runs run container1
+
+Most of the process, mounts and resources of a bundle can be set the same way,
+e.g.:
+
+    runs spec --args '["nginx","-g","daemon off;"]' --env-file app.env --user nginx \
+        --mount /srv/www:/usr/share/nginx/html:ro --memory 512m --cpus 1.5
 
 In the run command above, "container1" is the name for the instance of the
 container that you are starting. The name you provide for the container instance
@@ -61,8 +67,11 @@ generate a proper rootless spec file.
 
 Note that --rootless is not needed when you execute runc as the root in a user namespace
 created by an unprivileged user.
+
+The "validate" subcommand checks the specification file of a bundle, as create
+does before creating a container.
 `,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		cli.StringFlag{
 			Name:  "bundle, b",
 			Value: "",
@@ -72,6 +81,13 @@ created by an unprivileged user.
 			Name:  "rootless",
 			Usage: "generate a configuration for a rootless container",
 		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: `file the spec is written to, relative to the bundle, "-" for stdout (default "` + specConfig + `")`,
+		},
+	}, specGenFlags...),
+	Subcommands: []cli.Command{
+		specValidateCommand,
 	},
 	Action: func(context *cli.Context) error {
 		if err := checkArgs(context, 0, exactArgs); err != nil {
@@ -100,19 +116,42 @@ created by an unprivileged user.
 				return err
 			}
 		}
-		if err := checkNoFile(specConfig); err != nil {
+		if err := applySpecFlags(context, spec); err != nil {
 			return err
 		}
+		output := context.String("output")
+		if output == "" {
+			output = specConfig
+		}
 		data, err := json.MarshalIndent(spec, "", "\t")
 		if err != nil {
 			return err
 		}
-		return os.WriteFile(specConfig, data, 0o666)
+		if output == "-" {
+			_, err := os.Stdout.Write(append(data, '\n'))
+			return err
+		}
+		if err := checkNoFile(output); err != nil {
+			return err
+		}
+		return os.WriteFile(output, data, 0o666)
 	},
 }
 
 // loadSpec loads the specification from the provided path.
-func loadSpec(cPath string) (spec *specs.Spec, err error) {
+func loadSpec(cPath string) (*specs.Spec, error) {
+	spec, err := readSpec(cPath)
+	if err != nil {
+		return nil, err
+	}
+	if spec == nil {
+		return nil, fmt.Errorf("JSON specification file %s is empty", cPath)
+	}
+	return spec, validateProcessSpec(spec.Process)
+}
+
+// readSpec reads the specification from the provided path, unchecked.
+func readSpec(cPath string) (spec *specs.Spec, err error) {
 	cf, err := os.Open(cPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -125,5 +164,5 @@ func loadSpec(cPath string) (spec *specs.Spec, err error) {
 	if err = json.NewDecoder(cf).Decode(&spec); err != nil {
 		return nil, err
 	}
-	return spec, validateProcessSpec(spec.Process)
+	return spec, nil
 }
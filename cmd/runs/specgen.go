@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runc/libcontainer/capabilities"
+	"github.com/opencontainers/runc/libcontainer/user"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+)
+
+// cpuPeriod is the CFS period --cpus sets a quota for.
+const cpuPeriod = 100000
+
+// specGenFlags fill in the generated spec.
+var specGenFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "args",
+		Usage: `command of the process, split on spaces or given as a JSON array (e.g. --args '["sh","-c","echo hi"]')`,
+	},
+	cli.StringSliceFlag{
+		Name:  "env, e",
+		Usage: "set an environment variable as KEY=VALUE, or copy it from the environment of runs with KEY",
+	},
+	cli.StringSliceFlag{
+		Name:  "env-file",
+		Usage: "read environment variables from a file of KEY=VALUE lines, # starting a comment",
+	},
+	cli.StringFlag{
+		Name:  "cwd",
+		Usage: "absolute working directory of the process",
+	},
+	cli.StringFlag{
+		Name:  "user, u",
+		Usage: "user of the process as uid[:gid] or name[:group], names being looked up in the root filesystem",
+	},
+	cli.StringFlag{
+		Name:  "hostname",
+		Usage: "hostname of the container",
+	},
+	cli.StringSliceFlag{
+		Name:  "mount",
+		Usage: "bind mount src:dst[:opts], opts being comma separated mount options (e.g. /data:/data:ro)",
+	},
+	cli.BoolFlag{
+		Name:  "readonly",
+		Usage: "mount the root filesystem read-only, the default; --readonly=false makes it writable",
+	},
+	cli.BoolFlag{
+		Name:  "tty, t",
+		Usage: "allocate a terminal for the process, which has none without it",
+	},
+	cli.StringSliceFlag{
+		Name:  "cap-add",
+		Usage: "add a capability to the process (e.g. NET_ADMIN), ALL adding every known capability",
+	},
+	cli.StringSliceFlag{
+		Name:  "cap-drop",
+		Usage: "drop a capability from the process (e.g. NET_RAW), ALL dropping every capability",
+	},
+	cli.StringFlag{
+		Name:  "memory, m",
+		Usage: "memory limit (e.g. 512m, 2g)",
+	},
+	cli.Float64Flag{
+		Name:  "cpus",
+		Usage: "number of CPUs the container may use (e.g. 1.5)",
+	},
+	cli.StringSliceFlag{
+		Name:  "annotation",
+		Usage: "set an annotation as key=value",
+	},
+}
+
+// applySpecFlags fills in spec from specGenFlags. Root filesystem paths are
+// relative to the current directory, the bundle.
+func applySpecFlags(context *cli.Context, spec *specs.Spec) error {
+	if spec.Process == nil {
+		spec.Process = &specs.Process{}
+	}
+	process := spec.Process
+	if s := context.String("args"); s != "" {
+		args, err := parseArgs(s)
+		if err != nil {
+			return err
+		}
+		process.Args = args
+	}
+	env := process.Env
+	for _, path := range context.StringSlice("env-file") {
+		vars, err := readEnvFile(path)
+		if err != nil {
+			return err
+		}
+		env = mergeEnv(env, vars)
+	}
+	var vars []string
+	for _, v := range context.StringSlice("env") {
+		if !strings.Contains(v, "=") {
+			value, ok := os.LookupEnv(v)
+			if !ok {
+				continue
+			}
+			v += "=" + value
+		}
+		if strings.HasPrefix(v, "=") {
+			return fmt.Errorf("invalid environment variable %q", v)
+		}
+		vars = append(vars, v)
+	}
+	process.Env = mergeEnv(env, vars)
+	if cwd := context.String("cwd"); cwd != "" {
+		process.Cwd = cwd
+	}
+	if u := context.String("user"); u != "" {
+		if err := setSpecUser(spec, u); err != nil {
+			return err
+		}
+	}
+	if hostname := context.String("hostname"); hostname != "" {
+		spec.Hostname = hostname
+	}
+	for _, m := range context.StringSlice("mount") {
+		mount, err := parseMount(m)
+		if err != nil {
+			return err
+		}
+		spec.Mounts = append(spec.Mounts, mount)
+	}
+	if context.IsSet("readonly") {
+		if spec.Root == nil {
+			return errors.New("--readonly needs a root filesystem")
+		}
+		spec.Root.Readonly = context.Bool("readonly")
+	}
+	// The example spec has a terminal, which only --tty keeps.
+	process.Terminal = context.Bool("tty")
+	if err := setSpecCapabilities(process, context.StringSlice("cap-add"), context.StringSlice("cap-drop")); err != nil {
+		return err
+	}
+	if err := setSpecResources(context, spec); err != nil {
+		return err
+	}
+	for _, a := range context.StringSlice("annotation") {
+		key, value, ok := strings.Cut(a, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid annotation %q, expected key=value", a)
+		}
+		if spec.Annotations == nil {
+			spec.Annotations = make(map[string]string)
+		}
+		spec.Annotations[key] = value
+	}
+	return nil
+}
+
+// parseArgs parses the command of a process: a JSON array, or words separated
+// by spaces.
+func parseArgs(s string) ([]string, error) {
+	if strings.HasPrefix(strings.TrimSpace(s), "[") {
+		var args []string
+		if err := json.Unmarshal([]byte(s), &args); err != nil {
+			return nil, fmt.Errorf("invalid --args: %w", err)
+		}
+		if len(args) == 0 {
+			return nil, errors.New("--args must not be empty")
+		}
+		return args, nil
+	}
+	args := strings.Fields(s)
+	if len(args) == 0 {
+		return nil, errors.New("--args must not be empty")
+	}
+	return args, nil
+}
+
+// readEnvFile reads the KEY=VALUE lines of an environment file, skipping
+// blank lines and comments.
+func readEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var vars []string
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "=") || !strings.Contains(line, "=") {
+			return nil, fmt.Errorf("%s:%d: invalid environment variable %q, expected KEY=VALUE", path, n, line)
+		}
+		vars = append(vars, line)
+	}
+	return vars, s.Err()
+}
+
+// mergeEnv sets the KEY=VALUE variables of vars in env, replacing those with
+// the same key.
+func mergeEnv(env, vars []string) []string {
+	for _, v := range vars {
+		key, _, _ := strings.Cut(v, "=")
+		replaced := false
+		for i, e := range env {
+			if k, _, _ := strings.Cut(e, "="); k == key {
+				env[i] = v
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			env = append(env, v)
+		}
+	}
+	return env
+}
+
+// setSpecUser sets the user of the process, looking names up in the passwd
+// and group files of the root filesystem.
+func setSpecUser(spec *specs.Spec, u string) error {
+	var passwd, group string
+	if spec.Root != nil {
+		rootfs := spec.Root.Path
+		passwd = filepath.Join(rootfs, "etc", "passwd")
+		group = filepath.Join(rootfs, "etc", "group")
+	}
+	execUser, err := user.GetExecUserPath(u, &user.ExecUser{}, passwd, group)
+	if err != nil {
+		return fmt.Errorf("invalid --user %q: %w", u, err)
+	}
+	spec.Process.User = specs.User{
+		UID: uint32(execUser.Uid),
+		GID: uint32(execUser.Gid),
+	}
+	for _, gid := range execUser.Sgids {
+		spec.Process.User.AdditionalGids = append(spec.Process.User.AdditionalGids, uint32(gid))
+	}
+	return nil
+}
+
+// parseMount parses a bind mount given as src:dst[:opts].
+func parseMount(s string) (specs.Mount, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return specs.Mount{}, fmt.Errorf("invalid mount %q, expected src:dst[:opts]", s)
+	}
+	if !filepath.IsAbs(parts[1]) {
+		return specs.Mount{}, fmt.Errorf("invalid mount %q: destination must be absolute", s)
+	}
+	mount := specs.Mount{
+		Type:        "bind",
+		Source:      parts[0],
+		Destination: parts[1],
+		Options:     []string{"rbind"},
+	}
+	if len(parts) == 3 {
+		for _, opt := range strings.Split(parts[2], ",") {
+			if opt != "" {
+				mount.Options = append(mount.Options, opt)
+			}
+		}
+	}
+	return mount, nil
+}
+
+// setSpecCapabilities adds and drops capabilities of the bounding, effective
+// and permitted sets of the process, ALL standing for every known one.
+func setSpecCapabilities(process *specs.Process, add, drop []string) error {
+	if len(add) == 0 && len(drop) == 0 {
+		return nil
+	}
+	addCaps, err := capabilityNames(add)
+	if err != nil {
+		return err
+	}
+	dropCaps, err := capabilityNames(drop)
+	if err != nil {
+		return err
+	}
+	if process.Capabilities == nil {
+		process.Capabilities = &specs.LinuxCapabilities{}
+	}
+	c := process.Capabilities
+	for _, set := range []*[]string{&c.Bounding, &c.Effective, &c.Permitted} {
+		caps := *set
+		for _, name := range addCaps {
+			if !containsString(caps, name) {
+				caps = append(caps, name)
+			}
+		}
+		kept := caps[:0]
+		for _, name := range caps {
+			if !containsString(dropCaps, name) {
+				kept = append(kept, name)
+			}
+		}
+		*set = kept
+	}
+	return nil
+}
+
+// capabilityNames returns the CAP_ names of the given capabilities, which may
+// lack the prefix and be in lower case.
+func capabilityNames(names []string) ([]string, error) {
+	known := capabilities.KnownCapabilities()
+	var caps []string
+	for _, name := range names {
+		name = strings.ToUpper(name)
+		if name == "ALL" {
+			caps = append(caps, known...)
+			continue
+		}
+		if !strings.HasPrefix(name, "CAP_") {
+			name = "CAP_" + name
+		}
+		if !containsString(known, name) {
+			return nil, fmt.Errorf("unknown capability %q", name)
+		}
+		caps = append(caps, name)
+	}
+	return caps, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// setSpecResources sets the memory limit and CPU quota of the container.
+func setSpecResources(context *cli.Context, spec *specs.Spec) error {
+	memory, err := parseSize(context.String("memory"))
+	if err != nil {
+		return fmt.Errorf("invalid --memory: %w", err)
+	}
+	cpus := context.Float64("cpus")
+	if cpus < 0 || math.IsNaN(cpus) || math.IsInf(cpus, 0) {
+		return fmt.Errorf("invalid --cpus %s", strconv.FormatFloat(cpus, 'f', -1, 64))
+	}
+	if memory == 0 && cpus == 0 {
+		return nil
+	}
+	if spec.Linux == nil {
+		spec.Linux = &specs.Linux{}
+	}
+	if spec.Linux.Resources == nil {
+		spec.Linux.Resources = &specs.LinuxResources{}
+	}
+	r := spec.Linux.Resources
+	if memory > 0 {
+		if r.Memory == nil {
+			r.Memory = &specs.LinuxMemory{}
+		}
+		r.Memory.Limit = &memory
+	}
+	if cpus > 0 {
+		if r.CPU == nil {
+			r.CPU = &specs.LinuxCPU{}
+		}
+		quota := int64(math.Round(cpus * cpuPeriod))
+		period := uint64(cpuPeriod)
+		r.CPU.Quota = &quota
+		r.CPU.Period = &period
+	}
+	return nil
+}
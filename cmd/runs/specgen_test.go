@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+)
+
+func testSpecGenSpec() *specs.Spec {
+	caps := func() []string { return []string{"CAP_CHOWN", "CAP_KILL"} }
+	return &specs.Spec{
+		Root: &specs.Root{Path: "rootfs", Readonly: true},
+		Process: &specs.Process{
+			Args: []string{"sh"},
+			Cwd:  "/",
+			Env:  []string{"PATH=/bin", "TERM=xterm"},
+			Capabilities: &specs.LinuxCapabilities{
+				Bounding:  caps(),
+				Effective: caps(),
+				Permitted: caps(),
+			},
+		},
+	}
+}
+
+func TestApplySpecFlags(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), "env")
+	if err := os.WriteFile(envFile, []byte("# settings\nTERM=dumb\n\n  HOME=/root  \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	badEnvFile := filepath.Join(t.TempDir(), "env")
+	if err := os.WriteFile(badEnvFile, []byte("HOME=/root\nNOVALUE\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setCaps := func(s *specs.Spec, caps ...string) {
+		c := s.Process.Capabilities
+		c.Bounding, c.Effective, c.Permitted = append([]string{}, caps...), append([]string{}, caps...), append([]string{}, caps...)
+	}
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		// want changes the spec before the flags into the one expected
+		want    func(*specs.Spec)
+		wantErr bool
+	}{
+		{
+			name: "no flags",
+			want: func(s *specs.Spec) {},
+		},
+		{
+			name: "mount",
+			args: []string{"--mount", "/data:/data", "--mount", "/src:/dst:ro,,nosuid"},
+			want: func(s *specs.Spec) {
+				s.Mounts = []specs.Mount{
+					{Destination: "/data", Type: "bind", Source: "/data", Options: []string{"rbind"}},
+					{Destination: "/dst", Type: "bind", Source: "/src", Options: []string{"rbind", "ro", "nosuid"}},
+				}
+			},
+		},
+		{
+			name:    "mount without destination",
+			args:    []string{"--mount", "/data"},
+			wantErr: true,
+		},
+		{
+			name:    "mount relative destination",
+			args:    []string{"--mount", "/data:data"},
+			wantErr: true,
+		},
+		{
+			name: "memory",
+			args: []string{"--memory", "512m"},
+			want: func(s *specs.Spec) {
+				limit := int64(512 << 20)
+				s.Linux = &specs.Linux{Resources: &specs.LinuxResources{Memory: &specs.LinuxMemory{Limit: &limit}}}
+			},
+		},
+		{
+			name:    "memory invalid",
+			args:    []string{"--memory", "lots"},
+			wantErr: true,
+		},
+		{
+			name: "cpus",
+			args: []string{"--cpus", "1.5"},
+			want: func(s *specs.Spec) {
+				quota, period := int64(150000), uint64(100000)
+				s.Linux = &specs.Linux{Resources: &specs.LinuxResources{CPU: &specs.LinuxCPU{Quota: &quota, Period: &period}}}
+			},
+		},
+		{
+			name:    "cpus negative",
+			args:    []string{"--cpus", "-1"},
+			wantErr: true,
+		},
+		{
+			name: "env file",
+			args: []string{"--env-file", envFile, "--env", "HOME=/home", "-e", "LANG=C"},
+			want: func(s *specs.Spec) {
+				s.Process.Env = []string{"PATH=/bin", "TERM=dumb", "HOME=/home", "LANG=C"}
+			},
+		},
+		{
+			name:    "env file invalid",
+			args:    []string{"--env-file", badEnvFile},
+			wantErr: true,
+		},
+		{
+			name:    "env file missing",
+			args:    []string{"--env-file", filepath.Join(t.TempDir(), "missing")},
+			wantErr: true,
+		},
+		{
+			name: "cap add and drop",
+			args: []string{"--cap-add", "net_admin", "--cap-add", "CAP_CHOWN", "--cap-drop", "KILL"},
+			want: func(s *specs.Spec) { setCaps(s, "CAP_CHOWN", "CAP_NET_ADMIN") },
+		},
+		{
+			name: "cap drop all",
+			args: []string{"--cap-drop", "ALL", "--cap-add", "SYS_TIME"},
+			want: func(s *specs.Spec) { setCaps(s) },
+		},
+		{
+			name:    "cap unknown",
+			args:    []string{"--cap-add", "BOGUS"},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			set := flag.NewFlagSet("spec", flag.ContinueOnError)
+			for _, f := range specGenFlags {
+				f.Apply(set)
+			}
+			if err := set.Parse(tc.args); err != nil {
+				t.Fatal(err)
+			}
+			spec := testSpecGenSpec()
+			err := applySpecFlags(cli.NewContext(nil, set, nil), spec)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("applied %v, want an error", tc.args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := testSpecGenSpec()
+			tc.want(want)
+			if !reflect.DeepEqual(spec, want) {
+				got, _ := json.Marshal(spec)
+				wanted, _ := json.Marshal(want)
+				t.Fatalf("got %s\nwant %s", got, wanted)
+			}
+		})
+	}
+}
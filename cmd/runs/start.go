@@ -24,6 +24,9 @@ Where "<container-id>" is your name for the instance of the container that you
 are starting. The name you provide for the container instance must be unique on
 your host.`,
 	Description: `The start command executes the user defined process in a created container.`,
+	Flags: []cli.Flag{
+		timeoutFlag,
+	},
 	Action: func(context *cli.Context) error {
 		// if err := checkArgs(context, 1, exactArgs); err != nil {
 		// 	return err
@@ -61,6 +64,9 @@ your host.`,
 		// if err != nil {
 		// 	return err
 		// }
+		if err := setupTimeouts(context, containerRuntime(context, id)); err != nil {
+			return err
+		}
 		status := libcontainer.Created
 		switch status {
 		case libcontainer.Created:
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/kata-contrib/runs/pkg/shim"
+	"github.com/opencontainers/runc/libcontainer/user"
+	"github.com/urfave/cli"
+)
+
+var stateCommand = cli.Command{
+	Name:  "state",
+	Usage: "output the state of a container",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is your name for the instance of the container.`,
+	Description: `The state command outputs current state information for the
+instance of a container.`,
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+		id := context.Args().First()
+		root := context.GlobalString("root")
+		state, err := shim.LoadState(root, id)
+		if err != nil {
+			return fmt.Errorf("container %s: %w", id, err)
+		}
+		cs := newContainerState(id, state, stateOwner(filepath.Join(root, id)))
+		data, err := json.MarshalIndent(cs, "", "  ")
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(data)
+		return nil
+	},
+}
+
+var inspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "output everything runs records about a container",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is your name for the instance of the container.`,
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+		id := context.Args().First()
+		root := context.GlobalString("root")
+		state, err := shim.LoadState(root, id)
+		if err != nil {
+			return fmt.Errorf("container %s: %w", id, err)
+		}
+		data, err := json.MarshalIndent(struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+			Owner  string `json:"owner"`
+			*shim.State
+		}{
+			ID:     id,
+			Status: statusString(state.Status),
+			Owner:  stateOwner(filepath.Join(root, id)),
+			State:  state,
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(data)
+		return nil
+	},
+}
+
+// stateOwner returns the name of the owner of a container state directory.
+func stateOwner(path string) string {
+	st, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	// This cast is safe on Linux.
+	uid := st.Sys().(*syscall.Stat_t).Uid
+	owner, err := user.LookupUid(int(uid))
+	if err != nil {
+		return fmt.Sprintf("#%d", uid)
+	}
+	return owner.Name
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/kata-contrib/runs/pkg/cio"
+	"github.com/kata-contrib/runs/pkg/shim"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// rootFIFODir is where the IO FIFOs of root's containers are created, per
+// namespace.
+const rootFIFODir = "/run/runs/fifo"
+
+var ioFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "fifo-dir",
+		Usage: "directory the IO FIFOs are created in, " + rootFIFODir + "/<namespace> or /run/user/<uid>/runs/fifo/<namespace> when not root",
+	},
+	cli.BoolFlag{
+		Name:  "null-io",
+		Usage: "discard the output of the process and give it no input",
+	},
+	cli.BoolFlag{
+		Name:  "no-stdin",
+		Usage: "do not give the process a stdin",
+	},
+	cli.StringFlag{
+		Name:  "stdin-file",
+		Usage: "give the content of a file to the stdin of the process",
+	},
+}
+
+// checkIOFlags rejects combinations of ioFlags and --log-uri that contradict
+// each other.
+func checkIOFlags(context *cli.Context) error {
+	stdinFile := context.String("stdin-file")
+	if context.Bool("null-io") {
+		for _, name := range []string{"log-uri", "log-driver", "stdin-file"} {
+			if context.String(name) != "" {
+				return fmt.Errorf("--null-io cannot be used with --%s", name)
+			}
+		}
+		if context.Bool("no-stdin") {
+			return errors.New("--null-io cannot be used with --no-stdin")
+		}
+	}
+	if context.String("log-uri") != "" && stdinFile != "" {
+		return errors.New("--log-uri cannot be used with --stdin-file")
+	}
+	if context.Bool("no-stdin") && stdinFile != "" {
+		return errors.New("--no-stdin cannot be used with --stdin-file")
+	}
+	if stdinFile != "" {
+		if _, err := os.Stat(stdinFile); err != nil {
+			return fmt.Errorf("stdin file: %w", err)
+		}
+	}
+	return nil
+}
+
+// forwardStdin tells whether the stdin of runs goes to the process.
+func forwardStdin(context *cli.Context) bool {
+	return !context.Bool("null-io") && !context.Bool("no-stdin") && context.String("stdin-file") == ""
+}
+
+// defaultFIFODir returns the directory the IO FIFOs of a namespace are created
+// in: under the runtime directory of the user when not root.
+func defaultFIFODir(namespace string) string {
+	uid := os.Geteuid()
+	if uid == 0 {
+		return filepath.Join(rootFIFODir, namespace)
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join("/run/user", strconv.Itoa(uid))
+	}
+	return filepath.Join(dir, "runs", "fifo", namespace)
+}
+
+// fifoDir returns the directory of --fifo-dir, or the default one.
+func fifoDir(context *cli.Context, namespace string) string {
+	if dir := context.String("fifo-dir"); dir != "" {
+		return dir
+	}
+	return defaultFIFODir(namespace)
+}
+
+// removeFIFODir removes the directory of the IO FIFOs of a container, which
+// its monitor leaves behind when it is killed.
+func removeFIFODir(state *shim.State) error {
+	if state == nil || state.FIFODir == "" {
+		return nil
+	}
+	return os.RemoveAll(state.FIFODir)
+}
+
+// logIOStats logs what went through the IO of a process, and how it failed.
+func logIOStats(i cio.IO, id string) {
+	stats := i.Stats()
+	entry := logrus.WithFields(logrus.Fields{
+		"id":            id,
+		"stdin_bytes":   stats.Stdin.Bytes,
+		"stdout_bytes":  stats.Stdout.Bytes,
+		"stdout_chunks": stats.Stdout.Chunks,
+		"stderr_bytes":  stats.Stderr.Bytes,
+		"stderr_chunks": stats.Stderr.Chunks,
+	})
+	if err := i.Err(); err != nil {
+		entry.WithError(err).Warn("process IO failed")
+		return
+	}
+	entry.Debug("process IO done")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"time"
+
+	"github.com/containerd/containerd/pkg/timeout"
+	"github.com/kata-contrib/runs/pkg/config"
+	"github.com/kata-contrib/runs/pkg/shim"
+	"github.com/urfave/cli"
+)
+
+var timeoutFlag = cli.DurationFlag{
+	Name:  "timeout",
+	Usage: "override the shim load, cleanup and shutdown timeouts for this command (e.g. 30s)",
+}
+
+// shimTimeouts maps the global timeout flags onto the timeouts they set.
+var shimTimeouts = []struct {
+	flag string
+	name string
+}{
+	{"shim-load-timeout", shim.LoadTimeout},
+	{"shim-cleanup-timeout", shim.CleanupTimeout},
+	{"shim-shutdown-timeout", shim.ShutdownTimeout},
+}
+
+// loadConfig loads the runs configuration file. The default file is allowed
+// to be missing, one given explicitly is not.
+func loadConfig(context *cli.Context) (*config.Config, error) {
+	return config.Load(context.GlobalString("config"), context.GlobalIsSet("config"))
+}
+
+// setupTimeouts sets the shim timeouts used by the command from, in
+// increasing order of precedence, the config of the runtime handler, the
+// global flags or their environment variables, and the command's --timeout.
+func setupTimeouts(context *cli.Context, runtime string) error {
+	cfg, err := loadConfig(context)
+	if err != nil {
+		return err
+	}
+	if r, ok := cfg.Runtime(runtime); ok {
+		for name, d := range map[string]config.Duration{
+			shim.LoadTimeout:     r.Timeouts.Load,
+			shim.CleanupTimeout:  r.Timeouts.Cleanup,
+			shim.ShutdownTimeout: r.Timeouts.Shutdown,
+		} {
+			if d > 0 {
+				timeout.Set(name, time.Duration(d))
+			}
+		}
+	}
+	for _, t := range shimTimeouts {
+		if context.GlobalIsSet(t.flag) {
+			timeout.Set(t.name, context.GlobalDuration(t.flag))
+		}
+	}
+	if context.IsSet("timeout") {
+		for _, t := range shimTimeouts {
+			timeout.Set(t.name, context.Duration("timeout"))
+		}
+	}
+	return nil
+}
+
+// containerRuntime returns the runtime a container was created with, or an
+// empty string if its state cannot be read.
+func containerRuntime(context *cli.Context, id string) string {
+	state, err := shim.LoadState(context.GlobalString("root"), id)
+	if err != nil {
+		return ""
+	}
+	return state.Runtime
+}
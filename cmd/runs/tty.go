@@ -0,0 +1,177 @@
+package main
+
+import (
+	sctx "context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/containerd/console"
+	"github.com/containerd/containerd/runtime"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+const defaultDetachKeys = "ctrl-p,ctrl-q"
+
+var detachKeysFlag = cli.StringFlag{
+	Name:  "detach-keys",
+	Value: defaultDetachKeys,
+	Usage: "key sequence that detaches from the container and leaves it running, such as 'ctrl-p,ctrl-q' (empty to disable)",
+}
+
+// errDetached ends an interactive session on the detach key sequence.
+var errDetached = errors.New("detached from container")
+
+// parseDetachKeys parses a comma separated key sequence, where a key is a
+// single character or "ctrl-" followed by a letter or one of @[\]^_.
+func parseDetachKeys(keys string) ([]byte, error) {
+	if keys == "" {
+		return nil, nil
+	}
+	var seq []byte
+	for _, key := range strings.Split(keys, ",") {
+		switch {
+		case len(key) == 1:
+			seq = append(seq, key[0])
+		case strings.HasPrefix(key, "ctrl-") && len(key) == len("ctrl-")+1:
+			c := key[len("ctrl-")]
+			switch {
+			case c >= 'a' && c <= 'z':
+				seq = append(seq, c-'a'+1)
+			case c >= 'A' && c <= 'Z':
+				seq = append(seq, c-'A'+1)
+			case c == '@':
+				seq = append(seq, 0)
+			case c >= '[' && c <= '_':
+				seq = append(seq, c-'['+27)
+			default:
+				return nil, fmt.Errorf("invalid detach key %q", key)
+			}
+		default:
+			return nil, fmt.Errorf("invalid detach key %q", key)
+		}
+	}
+	return seq, nil
+}
+
+// detachReader passes input through until it sees the detach key sequence,
+// which it holds back and turns into errDetached. Keys of an incomplete
+// sequence are passed on once the sequence breaks.
+type detachReader struct {
+	r       io.Reader
+	keys    []byte
+	matched int
+	pending []byte
+	err     error
+}
+
+func (d *detachReader) Read(p []byte) (int, error) {
+	for {
+		if len(d.pending) > 0 {
+			n := copy(p, d.pending)
+			d.pending = d.pending[n:]
+			return n, nil
+		}
+		if d.err != nil {
+			return 0, d.err
+		}
+		if len(d.keys) == 0 {
+			return d.r.Read(p)
+		}
+		buf := make([]byte, len(p))
+		n, err := d.r.Read(buf)
+		d.pending = d.scan(buf[:n])
+		if d.err == nil && err != nil {
+			d.err = err
+			d.pending = append(d.pending, d.keys[:d.matched]...)
+		}
+	}
+}
+
+// scan returns the input to pass on, and sets errDetached once the sequence
+// is complete.
+func (d *detachReader) scan(in []byte) []byte {
+	var out []byte
+	for _, c := range in {
+		if c == d.keys[d.matched] {
+			d.matched++
+			if d.matched == len(d.keys) {
+				d.err = errDetached
+				return out
+			}
+			continue
+		}
+		out = append(out, d.keys[:d.matched]...)
+		d.matched = 0
+		if c == d.keys[0] {
+			d.matched = 1
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// newDetachReader wraps r to detach on the given key sequence.
+func newDetachReader(r io.Reader, keys string) (io.Reader, error) {
+	seq, err := parseDetachKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+	return &detachReader{r: r, keys: seq}, nil
+}
+
+// setRawTerminal puts the terminal on stdin in raw mode and returns it, so
+// that it can be reset.
+func setRawTerminal() (console.Console, error) {
+	c, err := console.ConsoleFromFile(os.Stdin)
+	if err != nil {
+		return nil, errors.New("the input device is not a TTY")
+	}
+	if err := c.SetRaw(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// resizer is a task or exec'd process with a pty.
+type resizer interface {
+	ResizePty(ctx sctx.Context, size runtime.ConsoleSize) error
+}
+
+// forwardResize sends the size of the terminal to the pty of p, then again on
+// every SIGWINCH until the returned function is called.
+func forwardResize(ctx sctx.Context, c console.Console, p resizer) func() {
+	resize := func() {
+		size, err := c.Size()
+		if err != nil || size.Width == 0 || size.Height == 0 {
+			return
+		}
+		if err := p.ResizePty(ctx, runtime.ConsoleSize{Width: uint32(size.Width), Height: uint32(size.Height)}); err != nil {
+			logrus.WithError(err).Debug("failed to resize pty")
+		}
+	}
+	resize()
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, unix.SIGWINCH)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-winch:
+				resize()
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(winch)
+		close(done)
+	}
+}
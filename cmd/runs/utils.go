@@ -128,3 +128,29 @@ func parseBoolOrAuto(s string) (*bool, error) {
 	b, err := strconv.ParseBool(s)
 	return &b, err
 }
+
+// parseSize parses a size in bytes with an optional k, m or g suffix
+// (powers of 1024), e.g. "512k" or "10m".
+func parseSize(size string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(size)), "b")
+	if s == "" {
+		return 0, nil
+	}
+	mult := int64(1)
+	switch s[len(s)-1] {
+	case 'k':
+		mult = 1 << 10
+	case 'm':
+		mult = 1 << 20
+	case 'g':
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/opencontainers/runc/libcontainer/capabilities"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/selinux/go-selinux"
+	"github.com/urfave/cli"
+)
+
+var specValidateCommand = cli.Command{
+	Name:  "validate",
+	Usage: "check the specification file of a bundle",
+	Description: `The validate command checks the specification file named "` + specConfig + `" of the
+bundle: the process, namespaces, mounts, resources, rlimits, capabilities,
+devices, hooks, annotations and the root filesystem. Every problem is printed
+with the JSON path of its field, and the command fails if there is any.
+
+The same checks run when a container is created.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "bundle, b",
+			Value: "",
+			Usage: "path to the root of the bundle directory, defaults to the current directory",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 0, exactArgs); err != nil {
+			return err
+		}
+		bundle := context.String("bundle")
+		if bundle == "" {
+			bundle = "."
+		}
+		spec, err := readSpec(filepath.Join(bundle, specConfig))
+		if err != nil {
+			return err
+		}
+		err = validateSpec(spec, bundle)
+		var problems specProblems
+		if !errors.As(err, &problems) {
+			return err
+		}
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		return fmt.Errorf("%s has %d problem(s)", filepath.Join(bundle, specConfig), len(problems))
+	},
+}
+
+// specProblem is a problem with a field of a spec.
+type specProblem struct {
+	// path is the JSON path of the field, e.g. process.rlimits[0].type
+	path string
+	msg  string
+}
+
+func (p specProblem) String() string {
+	return p.path + ": " + p.msg
+}
+
+// specProblems is the error of a spec with problems.
+type specProblems []specProblem
+
+func (p specProblems) Error() string {
+	msgs := make([]string, len(p))
+	for i, problem := range p {
+		msgs[i] = problem.String()
+	}
+	return fmt.Sprintf("invalid spec: %s", strings.Join(msgs, "; "))
+}
+
+func (p *specProblems) add(path, format string, args ...interface{}) {
+	*p = append(*p, specProblem{path: path, msg: fmt.Sprintf(format, args...)})
+}
+
+var (
+	rlimitTypes = map[string]bool{
+		"RLIMIT_AS": true, "RLIMIT_CORE": true, "RLIMIT_CPU": true,
+		"RLIMIT_DATA": true, "RLIMIT_FSIZE": true, "RLIMIT_LOCKS": true,
+		"RLIMIT_MEMLOCK": true, "RLIMIT_MSGQUEUE": true, "RLIMIT_NICE": true,
+		"RLIMIT_NOFILE": true, "RLIMIT_NPROC": true, "RLIMIT_RSS": true,
+		"RLIMIT_RTPRIO": true, "RLIMIT_RTTIME": true, "RLIMIT_SIGPENDING": true,
+		"RLIMIT_STACK": true,
+	}
+	namespaceTypes = map[specs.LinuxNamespaceType]bool{
+		specs.PIDNamespace: true, specs.NetworkNamespace: true,
+		specs.MountNamespace: true, specs.IPCNamespace: true,
+		specs.UTSNamespace: true, specs.UserNamespace: true,
+		specs.CgroupNamespace: true, "time": true,
+	}
+	// mountTypes are the filesystems mounted in containers. The host is no
+	// guide: with kata they are mounted in the guest.
+	mountTypes = map[string]bool{
+		"bind": true, "none": true, "proc": true, "sysfs": true,
+		"tmpfs": true, "devpts": true, "devtmpfs": true, "mqueue": true,
+		"cgroup": true, "cgroup2": true, "overlay": true, "hugetlbfs": true,
+		"ramfs": true, "securityfs": true, "binfmt_misc": true,
+		"debugfs": true, "tracefs": true, "configfs": true, "bpf": true,
+		"fuse": true, "9p": true, "virtiofs": true, "nfs": true, "nfs4": true,
+		"ext2": true, "ext3": true, "ext4": true, "xfs": true, "btrfs": true,
+		"vfat": true, "squashfs": true, "iso9660": true,
+	}
+	cpusetRE   = regexp.MustCompile(`^\d+(-\d+)?(,\d+(-\d+)?)*$`)
+	pageSizeRE = regexp.MustCompile(`^\d+[KMGT]B$`)
+)
+
+// validateSpec checks spec deeply enough that a bad spec is not left for the
+// shim or the agent to reject. Paths are relative to bundle.
+func validateSpec(spec *specs.Spec, bundle string) error {
+	var p specProblems
+	if spec == nil {
+		p.add("", "the spec is empty")
+		return p
+	}
+	if spec.Version == "" {
+		p.add("ociVersion", "must be set")
+	}
+	validateRoot(&p, spec.Root, bundle)
+	validateProcess(&p, spec.Process)
+	for i, m := range spec.Mounts {
+		validateMount(&p, fmt.Sprintf("mounts[%d]", i), m, bundle)
+	}
+	if spec.Hooks != nil {
+		for name, hooks := range map[string][]specs.Hook{
+			"prestart":        spec.Hooks.Prestart,
+			"createRuntime":   spec.Hooks.CreateRuntime,
+			"createContainer": spec.Hooks.CreateContainer,
+			"startContainer":  spec.Hooks.StartContainer,
+			"poststart":       spec.Hooks.Poststart,
+			"poststop":        spec.Hooks.Poststop,
+		} {
+			for i, h := range hooks {
+				// startContainer hooks run in the container, for kata
+				// in the guest, where the host cannot look them up.
+				validateHook(&p, fmt.Sprintf("hooks.%s[%d]", name, i), h, name != "startContainer")
+			}
+		}
+	}
+	for key := range spec.Annotations {
+		path := fmt.Sprintf("annotations[%q]", key)
+		switch {
+		case key == "":
+			p.add(path, "key must not be empty")
+		case strings.IndexFunc(key, func(r rune) bool { return unicode.IsSpace(r) || !unicode.IsPrint(r) }) >= 0:
+			p.add(path, "key must not hold spaces or control characters")
+		}
+	}
+	validateLinux(&p, spec)
+	if len(p) == 0 {
+		return nil
+	}
+	// Maps are walked in random order.
+	sort.SliceStable(p, func(i, j int) bool { return p[i].path < p[j].path })
+	return p
+}
+
+func validateRoot(p *specProblems, root *specs.Root, bundle string) {
+	if root == nil || root.Path == "" {
+		p.add("root.path", "must be set")
+		return
+	}
+	path := root.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(bundle, path)
+	}
+	fi, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		p.add("root.path", "%s does not exist", path)
+	case err != nil:
+		p.add("root.path", "%v", err)
+	case !fi.IsDir():
+		p.add("root.path", "%s is not a directory", path)
+	}
+}
+
+func validateProcess(p *specProblems, process *specs.Process) {
+	if process == nil {
+		p.add("process", "must be set")
+		return
+	}
+	if len(process.Args) == 0 {
+		p.add("process.args", "must not be empty")
+	}
+	if process.Cwd == "" {
+		p.add("process.cwd", "must be set")
+	} else if !filepath.IsAbs(process.Cwd) {
+		p.add("process.cwd", "%q is not an absolute path", process.Cwd)
+	}
+	for i, env := range process.Env {
+		if key, _, ok := strings.Cut(env, "="); !ok || key == "" {
+			p.add(fmt.Sprintf("process.env[%d]", i), "%q is not KEY=VALUE", env)
+		}
+	}
+	seen := make(map[string]bool)
+	for i, r := range process.Rlimits {
+		path := fmt.Sprintf("process.rlimits[%d]", i)
+		switch {
+		case !rlimitTypes[r.Type]:
+			p.add(path+".type", "unknown rlimit %q", r.Type)
+		case seen[r.Type]:
+			p.add(path+".type", "%s is set more than once", r.Type)
+		}
+		seen[r.Type] = true
+		if r.Soft > r.Hard {
+			p.add(path+".soft", "%d is above the hard limit %d", r.Soft, r.Hard)
+		}
+	}
+	if c := process.Capabilities; c != nil {
+		known := make(map[string]bool)
+		for _, name := range capabilities.KnownCapabilities() {
+			known[name] = true
+		}
+		for set, caps := range map[string][]string{
+			"bounding":    c.Bounding,
+			"effective":   c.Effective,
+			"inheritable": c.Inheritable,
+			"permitted":   c.Permitted,
+			"ambient":     c.Ambient,
+		} {
+			for i, name := range caps {
+				if !known[name] {
+					p.add(fmt.Sprintf("process.capabilities.%s[%d]", set, i), "unknown capability %q", name)
+				}
+			}
+		}
+	}
+	if process.SelinuxLabel != "" && !selinux.GetEnabled() {
+		p.add("process.selinuxLabel", "selinux is disabled or not supported")
+	}
+}
+
+func validateMount(p *specProblems, path string, m specs.Mount, bundle string) {
+	if m.Destination == "" {
+		p.add(path+".destination", "must be set")
+	} else if !filepath.IsAbs(m.Destination) {
+		p.add(path+".destination", "%q is not an absolute path", m.Destination)
+	} else if filepath.Clean(m.Destination) == "/" {
+		p.add(path+".destination", "cannot mount over the root filesystem")
+	}
+	bind := m.Type == "bind"
+	for _, opt := range m.Options {
+		if opt == "bind" || opt == "rbind" {
+			bind = true
+		}
+	}
+	if m.Type != "" && !mountTypes[m.Type] {
+		p.add(path+".type", "unknown filesystem %q", m.Type)
+	}
+	if bind {
+		source := m.Source
+		if source == "" {
+			p.add(path+".source", "a bind mount needs a source")
+			return
+		}
+		if !filepath.IsAbs(source) {
+			source = filepath.Join(bundle, source)
+		}
+		if _, err := os.Stat(source); err != nil {
+			p.add(path+".source", "%v", err)
+		}
+	}
+}
+
+// validateHook checks a hook, and that its program exists when it runs on
+// the host.
+func validateHook(p *specProblems, path string, h specs.Hook, onHost bool) {
+	if !filepath.IsAbs(h.Path) {
+		p.add(path+".path", "%q is not an absolute path", h.Path)
+	} else if onHost {
+		fi, err := os.Stat(h.Path)
+		switch {
+		case err != nil:
+			p.add(path+".path", "%v", err)
+		case !fi.Mode().IsRegular() || fi.Mode().Perm()&0o111 == 0:
+			p.add(path+".path", "%s is not an executable file", h.Path)
+		}
+	}
+	if h.Timeout != nil && *h.Timeout <= 0 {
+		p.add(path+".timeout", "must be positive")
+	}
+}
+
+func validateLinux(p *specProblems, spec *specs.Spec) {
+	linux := spec.Linux
+	if linux == nil {
+		return
+	}
+	seen := make(map[specs.LinuxNamespaceType]bool)
+	for i, ns := range linux.Namespaces {
+		path := fmt.Sprintf("linux.namespaces[%d]", i)
+		switch {
+		case !namespaceTypes[ns.Type]:
+			p.add(path+".type", "unknown namespace %q", ns.Type)
+		case seen[ns.Type]:
+			p.add(path+".type", "%s namespace is set more than once", ns.Type)
+		}
+		seen[ns.Type] = true
+		if ns.Path != "" && !filepath.IsAbs(ns.Path) {
+			p.add(path+".path", "%q is not an absolute path", ns.Path)
+		}
+	}
+	if spec.Hostname != "" && !seen[specs.UTSNamespace] {
+		p.add("hostname", "needs a uts namespace")
+	}
+	if seen[specs.UserNamespace] {
+		if len(linux.UIDMappings) == 0 {
+			p.add("linux.uidMappings", "a user namespace needs uid mappings")
+		}
+		if len(linux.GIDMappings) == 0 {
+			p.add("linux.gidMappings", "a user namespace needs gid mappings")
+		}
+	}
+	devices := make(map[string]bool)
+	for i, d := range linux.Devices {
+		path := fmt.Sprintf("linux.devices[%d]", i)
+		if !filepath.IsAbs(d.Path) {
+			p.add(path+".path", "%q is not an absolute path", d.Path)
+		} else if devices[d.Path] {
+			p.add(path+".path", "%s is set more than once", d.Path)
+		}
+		devices[d.Path] = true
+		switch d.Type {
+		case "c", "b", "u":
+			if d.Major < 0 || d.Minor < 0 {
+				p.add(path, "major and minor must not be negative")
+			}
+		case "p":
+		default:
+			p.add(path+".type", "unknown device type %q, expected c, b, u or p", d.Type)
+		}
+	}
+	if linux.Resources != nil {
+		validateResources(p, linux.Resources)
+	}
+}
+
+func validateResources(p *specProblems, r *specs.LinuxResources) {
+	for i, d := range r.Devices {
+		path := fmt.Sprintf("linux.resources.devices[%d]", i)
+		switch d.Type {
+		case "", "a", "b", "c":
+		default:
+			p.add(path+".type", "unknown device type %q, expected a, b or c", d.Type)
+		}
+		if strings.Trim(d.Access, "rwm") != "" {
+			p.add(path+".access", "%q is not a combination of r, w and m", d.Access)
+		}
+	}
+	if m := r.Memory; m != nil {
+		for name, v := range map[string]*int64{
+			"limit": m.Limit, "reservation": m.Reservation, "swap": m.Swap,
+			"kernel": m.Kernel, "kernelTCP": m.KernelTCP,
+		} {
+			if v != nil && *v < -1 {
+				p.add("linux.resources.memory."+name, "must be -1 (unlimited) or a size in bytes")
+			}
+		}
+		if m.Limit != nil && *m.Limit > 0 {
+			if m.Reservation != nil && *m.Reservation > *m.Limit {
+				p.add("linux.resources.memory.reservation", "is above the limit")
+			}
+			if m.Swap != nil && *m.Swap > 0 && *m.Swap < *m.Limit {
+				p.add("linux.resources.memory.swap", "is below the limit, which it includes")
+			}
+		}
+		if m.Swappiness != nil && *m.Swappiness > 100 {
+			p.add("linux.resources.memory.swappiness", "%d is above 100", *m.Swappiness)
+		}
+	}
+	if c := r.CPU; c != nil {
+		if c.Shares != nil && (*c.Shares < 2 || *c.Shares > 262144) {
+			p.add("linux.resources.cpu.shares", "%d is out of the range 2-262144", *c.Shares)
+		}
+		if c.Quota != nil && *c.Quota != -1 && *c.Quota != 0 && *c.Quota < 1000 {
+			p.add("linux.resources.cpu.quota", "must be -1 (unlimited) or at least 1000")
+		}
+		if c.Period != nil && *c.Period != 0 && (*c.Period < 1000 || *c.Period > 1000000) {
+			p.add("linux.resources.cpu.period", "%d is out of the range 1000-1000000", *c.Period)
+		}
+		if c.Cpus != "" && !cpusetRE.MatchString(c.Cpus) {
+			p.add("linux.resources.cpu.cpus", "%q is not a list of CPUs such as 0-3,6", c.Cpus)
+		}
+		if c.Mems != "" && !cpusetRE.MatchString(c.Mems) {
+			p.add("linux.resources.cpu.mems", "%q is not a list of memory nodes such as 0-1", c.Mems)
+		}
+	}
+	if r.Pids != nil && r.Pids.Limit < -1 {
+		p.add("linux.resources.pids.limit", "must be -1 (unlimited) or a number of processes")
+	}
+	if b := r.BlockIO; b != nil {
+		checkWeight := func(path string, w *uint16) {
+			if w != nil && *w != 0 && (*w < 10 || *w > 1000) {
+				p.add(path, "%d is out of the range 10-1000", *w)
+			}
+		}
+		checkWeight("linux.resources.blockIO.weight", b.Weight)
+		checkWeight("linux.resources.blockIO.leafWeight", b.LeafWeight)
+		for i, d := range b.WeightDevice {
+			path := fmt.Sprintf("linux.resources.blockIO.weightDevice[%d]", i)
+			checkWeight(path+".weight", d.Weight)
+			checkWeight(path+".leafWeight", d.LeafWeight)
+		}
+	}
+	for i, h := range r.HugepageLimits {
+		if !pageSizeRE.MatchString(h.Pagesize) {
+			p.add(fmt.Sprintf("linux.resources.hugepageLimits[%d].pageSize", i), "%q is not a page size such as 2MB", h.Pagesize)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func int64p(v int64) *int64    { return &v }
+func uint64p(v uint64) *uint64 { return &v }
+func uint16p(v uint16) *uint16 { return &v }
+func intp(v int) *int          { return &v }
+
+// validTestSpec returns a spec valid for a bundle with a rootfs directory and
+// a file named file.
+func validTestSpec() *specs.Spec {
+	return &specs.Spec{
+		Version: specs.Version,
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{
+			Args: []string{"sh"},
+			Cwd:  "/",
+			Env:  []string{"PATH=/bin"},
+		},
+		Hostname: "c1",
+		Mounts: []specs.Mount{
+			{Destination: "/proc", Type: "proc", Source: "proc"},
+		},
+		Linux: &specs.Linux{
+			Namespaces: []specs.LinuxNamespace{{Type: specs.PIDNamespace}, {Type: specs.UTSNamespace}},
+		},
+	}
+}
+
+func TestValidateSpec(t *testing.T) {
+	bundle := t.TempDir()
+	if err := os.Mkdir(filepath.Join(bundle, "rootfs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bundle, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(*specs.Spec)
+		// paths are the JSON paths of the expected problems, sorted
+		paths []string
+	}{
+		{
+			name:   "valid",
+			modify: func(s *specs.Spec) {},
+		},
+		{
+			name: "root",
+			modify: func(s *specs.Spec) {
+				s.Version = ""
+				s.Root.Path = "missing"
+			},
+			paths: []string{"ociVersion", "root.path"},
+		},
+		{
+			name:   "root not a directory",
+			modify: func(s *specs.Spec) { s.Root.Path = "file" },
+			paths:  []string{"root.path"},
+		},
+		{
+			name:   "no process",
+			modify: func(s *specs.Spec) { s.Process = nil },
+			paths:  []string{"process"},
+		},
+		{
+			name: "process",
+			modify: func(s *specs.Spec) {
+				s.Process.Args = nil
+				s.Process.Cwd = "relative"
+				s.Process.Env = []string{"PATH=/bin", "NOVALUE", "=value"}
+			},
+			paths: []string{"process.args", "process.cwd", "process.env[1]", "process.env[2]"},
+		},
+		{
+			name: "rlimits",
+			modify: func(s *specs.Spec) {
+				s.Process.Rlimits = []specs.POSIXRlimit{
+					{Type: "RLIMIT_NOFILE", Soft: 1024, Hard: 4096},
+					{Type: "RLIMIT_NOFILE", Soft: 1024, Hard: 4096},
+					{Type: "RLIMIT_BOGUS"},
+					{Type: "RLIMIT_CORE", Soft: 2, Hard: 1},
+				}
+			},
+			paths: []string{"process.rlimits[1].type", "process.rlimits[2].type", "process.rlimits[3].soft"},
+		},
+		{
+			name: "capabilities",
+			modify: func(s *specs.Spec) {
+				s.Process.Capabilities = &specs.LinuxCapabilities{
+					Bounding:  []string{"CAP_CHOWN", "CAP_BOGUS"},
+					Effective: []string{"CHOWN"},
+				}
+			},
+			paths: []string{"process.capabilities.bounding[1]", "process.capabilities.effective[0]"},
+		},
+		{
+			name: "mounts",
+			modify: func(s *specs.Spec) {
+				s.Mounts = []specs.Mount{
+					{Destination: "relative", Type: "tmpfs"},
+					{Destination: "/", Type: "tmpfs"},
+					{Destination: "/data", Type: "bogusfs"},
+					{Destination: "/bind", Type: "bind"},
+					{Destination: "/rbind", Options: []string{"rbind"}, Source: "missing"},
+					{Destination: "/file", Type: "bind", Source: "file"},
+					// Filesystems of the guest, the host is no guide.
+					{Destination: "/shared", Type: "virtiofs", Source: "shared"},
+				}
+			},
+			paths: []string{"mounts[0].destination", "mounts[1].destination", "mounts[2].type", "mounts[3].source", "mounts[4].source"},
+		},
+		{
+			name: "hooks",
+			modify: func(s *specs.Spec) {
+				s.Hooks = &specs.Hooks{
+					Prestart:      []specs.Hook{{Path: "relative"}},
+					CreateRuntime: []specs.Hook{{Path: "/nonexistent/hook"}},
+					Poststop:      []specs.Hook{{Path: filepath.Join(bundle, "file")}},
+					Poststart:     []specs.Hook{{Path: "/bin/sh", Timeout: intp(0)}},
+					// Looked up in the container, not on the host.
+					StartContainer: []specs.Hook{{Path: "/nonexistent/hook"}, {Path: "relative"}},
+				}
+			},
+			paths: []string{
+				"hooks.createRuntime[0].path",
+				"hooks.poststart[0].timeout",
+				"hooks.poststop[0].path",
+				"hooks.prestart[0].path",
+				"hooks.startContainer[1].path",
+			},
+		},
+		{
+			name: "annotations",
+			modify: func(s *specs.Spec) {
+				s.Annotations = map[string]string{"": "empty", "with space": "x", "io.katacontainers.ok": "x"}
+			},
+			paths: []string{`annotations[""]`, `annotations["with space"]`},
+		},
+		{
+			name: "namespaces",
+			modify: func(s *specs.Spec) {
+				s.Linux.Namespaces = []specs.LinuxNamespace{
+					{Type: specs.PIDNamespace},
+					{Type: specs.PIDNamespace},
+					{Type: "bogus"},
+					{Type: specs.NetworkNamespace, Path: "relative"},
+					{Type: specs.UserNamespace},
+				}
+			},
+			paths: []string{
+				"hostname",
+				"linux.gidMappings",
+				"linux.namespaces[1].type",
+				"linux.namespaces[2].type",
+				"linux.namespaces[3].path",
+				"linux.uidMappings",
+			},
+		},
+		{
+			name: "devices",
+			modify: func(s *specs.Spec) {
+				s.Linux.Devices = []specs.LinuxDevice{
+					{Path: "/dev/fuse", Type: "c", Major: 10, Minor: 229},
+					{Path: "/dev/fuse", Type: "c", Major: 10, Minor: 229},
+					{Path: "dev/null", Type: "c", Major: 1, Minor: 3},
+					{Path: "/dev/x", Type: "z"},
+					{Path: "/dev/y", Type: "b", Major: -1},
+				}
+			},
+			paths: []string{"linux.devices[1].path", "linux.devices[2].path", "linux.devices[3].type", "linux.devices[4]"},
+		},
+		{
+			name: "resources",
+			modify: func(s *specs.Spec) {
+				s.Linux.Resources = &specs.LinuxResources{
+					Devices: []specs.LinuxDeviceCgroup{{Type: "x", Access: "rwx"}},
+					Memory: &specs.LinuxMemory{
+						Limit:       int64p(1 << 20),
+						Reservation: int64p(2 << 20),
+						Swap:        int64p(1 << 10),
+						Kernel:      int64p(-2),
+						Swappiness:  uint64p(101),
+					},
+					CPU: &specs.LinuxCPU{
+						Shares: uint64p(1),
+						Quota:  int64p(10),
+						Period: uint64p(10),
+						Cpus:   "0-3,a",
+						Mems:   "0,,1",
+					},
+					Pids: &specs.LinuxPids{Limit: -2},
+					BlockIO: &specs.LinuxBlockIO{
+						Weight:       uint16p(5),
+						LeafWeight:   uint16p(2000),
+						WeightDevice: []specs.LinuxWeightDevice{{Weight: uint16p(1)}},
+					},
+					HugepageLimits: []specs.LinuxHugepageLimit{{Pagesize: "2M"}},
+				}
+			},
+			paths: []string{
+				"linux.resources.blockIO.leafWeight",
+				"linux.resources.blockIO.weight",
+				"linux.resources.blockIO.weightDevice[0].weight",
+				"linux.resources.cpu.cpus",
+				"linux.resources.cpu.mems",
+				"linux.resources.cpu.period",
+				"linux.resources.cpu.quota",
+				"linux.resources.cpu.shares",
+				"linux.resources.devices[0].access",
+				"linux.resources.devices[0].type",
+				"linux.resources.hugepageLimits[0].pageSize",
+				"linux.resources.memory.kernel",
+				"linux.resources.memory.reservation",
+				"linux.resources.memory.swap",
+				"linux.resources.memory.swappiness",
+				"linux.resources.pids.limit",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := validTestSpec()
+			tc.modify(spec)
+			err := validateSpec(spec, bundle)
+			var paths []string
+			if err != nil {
+				var problems specProblems
+				if !errors.As(err, &problems) {
+					t.Fatalf("not a list of problems: %v", err)
+				}
+				for _, problem := range problems {
+					paths = append(paths, problem.path)
+				}
+			}
+			if !reflect.DeepEqual(paths, tc.paths) {
+				t.Fatalf("problems at %q, want %q\n%v", paths, tc.paths, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// Command logfile is a logging binary for "runs --log-uri binary:///path/to/logfile",
+// the shim starts it with the stdout and stderr of the container on fds 3 and
+// 4. It appends both to a file, given by the "path" key of the log URI:
+//
+//	runs create --log-uri 'binary:///usr/local/bin/logfile?path=/var/log/c1.log' c1
+//
+// Without a path, it logs to /var/log/runs/<namespace>/<container-id>.log.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/containerd/containerd/runtime/v2/logging"
+)
+
+const defaultLogDir = "/var/log/runs"
+
+func main() {
+	logging.Run(logFile)
+}
+
+func logFile(ctx context.Context, config *logging.Config, ready func() error) error {
+	path, err := logPath(config)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	copyStream := func(r io.Reader) {
+		defer wg.Done()
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				mu.Lock()
+				f.Write(buf[:n])
+				mu.Unlock()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go copyStream(config.Stdout)
+	go copyStream(config.Stderr)
+
+	// The shim waits for ready before it starts the container.
+	if err := ready(); err != nil {
+		return err
+	}
+	wg.Wait()
+	return nil
+}
+
+// logPath returns the file to log to: the argument after "path" on the
+// command line, which the shim builds from the query of the log URI.
+func logPath(config *logging.Config) (string, error) {
+	args := os.Args[1:]
+	for i := 0; i < len(args); i++ {
+		if args[i] != "path" {
+			continue
+		}
+		if i+1 == len(args) || args[i+1] == "" {
+			return "", fmt.Errorf("path of the log file is empty")
+		}
+		return args[i+1], nil
+	}
+	return filepath.Join(defaultLogDir, config.Namespace, config.ID+".log"), nil
+}
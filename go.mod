@@ -3,17 +3,22 @@ module github.com/kata-contrib/runs
 go 1.18
 
 require (
+	github.com/containerd/console v1.0.3
 	github.com/containerd/containerd v1.6.1
 	github.com/containerd/fifo v1.0.0
 	github.com/containerd/ttrpc v1.1.1-0.20220420014843-944ef4a40df3
+	github.com/containerd/typeurl v1.0.3-0.20220422153119-7f6e6d160d67
+	github.com/cyphar/filepath-securejoin v0.2.3
 	github.com/hashicorp/go-multierror v1.1.1
 	github.com/opencontainers/image-spec v1.0.3-0.20220303224323-02efb9a75ee1
 	github.com/opencontainers/runc v1.1.3
 	github.com/opencontainers/runtime-spec v1.0.3-0.20210326190908-1c3f411f0417
 	github.com/opencontainers/selinux v1.10.1
+	github.com/pelletier/go-toml v1.9.3
 	github.com/sirupsen/logrus v1.8.1
 	github.com/urfave/cli v1.22.9
 	golang.org/x/sys v0.0.0-20220702020025-31831981b65f
+	google.golang.org/protobuf v1.28.0
 )
 
 require (
@@ -22,12 +27,9 @@ require (
 	github.com/checkpoint-restore/go-criu/v5 v5.3.0 // indirect
 	github.com/cilium/ebpf v0.9.0 // indirect
 	github.com/containerd/cgroups v1.0.4 // indirect
-	github.com/containerd/console v1.0.3 // indirect
 	github.com/containerd/go-runc v1.0.0 // indirect
-	github.com/containerd/typeurl v1.0.3-0.20220422153119-7f6e6d160d67 // indirect
 	github.com/coreos/go-systemd/v22 v22.3.2 // indirect
 	github.com/cpuguy83/go-md2man/v2 v2.0.1 // indirect
-	github.com/cyphar/filepath-securejoin v0.2.3 // indirect
 	github.com/docker/go-events v0.0.0-20190806004212-e31b211e4f1c // indirect
 	github.com/godbus/dbus/v5 v5.1.0 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
@@ -49,11 +51,9 @@ require (
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
 	google.golang.org/genproto v0.0.0-20220426171045-31bebdecfb46 // indirect
 	google.golang.org/grpc v1.47.0 // indirect
-	google.golang.org/protobuf v1.28.0 // indirect
 )
 
 replace (
 	github.com/containerd/containerd => github.com/containerd/containerd v1.6.1-0.20220630145236-7eae7f206ca0
 	github.com/opencontainers/runc => github.com/opencontainers/runc v1.1.1-0.20220629213802-eca233d286e8
-
 )
@@ -0,0 +1,291 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cio
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Event types of an asciicast.
+const (
+	AsciicastOutput = "o"
+	AsciicastInput  = "i"
+	AsciicastResize = "r"
+)
+
+// AsciicastHeader is the first line of an asciicast v2 recording.
+type AsciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     uint32            `json:"width"`
+	Height    uint32            `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// AsciicastEvent is an event of an asciicast v2 recording, a line
+// [time, type, data] with the time in seconds since the start.
+type AsciicastEvent struct {
+	Time float64
+	Type string
+	Data string
+}
+
+// MarshalJSON encodes an event as a JSON array.
+func (e AsciicastEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Time, e.Type, e.Data})
+}
+
+// UnmarshalJSON decodes an event from a JSON array.
+func (e *AsciicastEvent) UnmarshalJSON(data []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("asciicast event has %d fields, expected 3", len(fields))
+	}
+	if err := json.Unmarshal(fields[0], &e.Time); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(fields[1], &e.Type); err != nil {
+		return err
+	}
+	return json.Unmarshal(fields[2], &e.Data)
+}
+
+// Recorder writes a terminal session to an asciicast v2 recording. Its
+// Output and Input writers tee the streams of a session into the recording.
+type Recorder struct {
+	mu    sync.Mutex
+	w     *bufio.Writer
+	start time.Time
+	input bool
+	err   error
+	// partial holds the end of the last write of a stream when it cuts an
+	// UTF-8 sequence, which events must not do.
+	partial map[string][]byte
+}
+
+// NewRecorder writes the header of a recording of a width by height terminal
+// to w. Input is only recorded when input is set.
+func NewRecorder(w io.Writer, width, height uint32, input bool) (*Recorder, error) {
+	r := &Recorder{
+		w:       bufio.NewWriter(w),
+		start:   time.Now(),
+		input:   input,
+		partial: make(map[string][]byte),
+	}
+	env := make(map[string]string)
+	for _, key := range []string{"TERM", "SHELL"} {
+		if v := os.Getenv(key); v != "" {
+			env[key] = v
+		}
+	}
+	header, err := json.Marshal(AsciicastHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Env:       env,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.w.Write(append(header, '\n')); err != nil {
+		return nil, err
+	}
+	return r, r.w.Flush()
+}
+
+// Output returns a writer recording output events.
+func (r *Recorder) Output() io.Writer {
+	return recorderStream{r: r, typ: AsciicastOutput}
+}
+
+// Input returns a writer recording input events, which discards them unless
+// the recorder records input.
+func (r *Recorder) Input() io.Writer {
+	if !r.input {
+		return io.Discard
+	}
+	return recorderStream{r: r, typ: AsciicastInput}
+}
+
+// Resize records a resize of the terminal.
+func (r *Recorder) Resize(width, height uint32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.event(AsciicastResize, strconv.FormatUint(uint64(width), 10)+"x"+strconv.FormatUint(uint64(height), 10))
+}
+
+// Close writes what is left of the streams and flushes the recording. It
+// returns the first error writing the recording.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, typ := range []string{AsciicastOutput, AsciicastInput} {
+		if data := r.partial[typ]; len(data) > 0 {
+			r.event(typ, string(data))
+		}
+	}
+	r.partial = nil
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	return r.err
+}
+
+// write records data of a stream, holding back an UTF-8 sequence cut at its
+// end until the next write.
+func (r *Recorder) write(typ string, p []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.partial == nil {
+		return errors.New("recorder is closed")
+	}
+	data := append(r.partial[typ], p...)
+	cut := incompleteUTF8(data)
+	r.partial[typ] = append([]byte(nil), data[len(data)-cut:]...)
+	if len(data) == cut {
+		return nil
+	}
+	if err := r.event(typ, string(data[:len(data)-cut])); err != nil {
+		return err
+	}
+	// Flush so that a session cut short leaves a playable recording.
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	return r.err
+}
+
+// event writes an event, keeping the first error. It is called with mu held.
+func (r *Recorder) event(typ, data string) error {
+	if r.err != nil {
+		return r.err
+	}
+	line, err := json.Marshal(AsciicastEvent{
+		Time: time.Since(r.start).Seconds(),
+		Type: typ,
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+	_, r.err = r.w.Write(append(line, '\n'))
+	return r.err
+}
+
+// incompleteUTF8 returns the length of the UTF-8 sequence cut at the end of
+// p, if any.
+func incompleteUTF8(p []byte) int {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(p); i++ {
+		c := p[len(p)-i]
+		if c < utf8.RuneSelf {
+			return 0
+		}
+		if utf8.RuneStart(c) {
+			if utf8.FullRune(p[len(p)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+type recorderStream struct {
+	r   *Recorder
+	typ string
+}
+
+// Write records p and never fails, so that a broken recording does not break
+// the session it tees; Recorder.Close reports the error.
+func (s recorderStream) Write(p []byte) (int, error) {
+	s.r.write(s.typ, p)
+	return len(p), nil
+}
+
+// WithRecorder tees the output of the streams, and their stdin when it
+// records input, into a recording. It must follow the options setting the
+// streams.
+func WithRecorder(r *Recorder) Opt {
+	return func(opt *Streams) {
+		if opt.Stdout != nil {
+			opt.Stdout = io.MultiWriter(opt.Stdout, r.Output())
+		}
+		if opt.Stderr != nil {
+			opt.Stderr = io.MultiWriter(opt.Stderr, r.Output())
+		}
+		if opt.Stdin != nil && r.input {
+			opt.Stdin = io.TeeReader(opt.Stdin, r.Input())
+		}
+	}
+}
+
+// AsciicastReader reads the events of an asciicast v2 recording.
+type AsciicastReader struct {
+	Header AsciicastHeader
+	s      *bufio.Scanner
+}
+
+// NewAsciicastReader reads the header of a recording.
+func NewAsciicastReader(r io.Reader) (*AsciicastReader, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64<<10), 16<<20)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("empty asciicast recording")
+	}
+	a := &AsciicastReader{s: s}
+	if err := json.Unmarshal(s.Bytes(), &a.Header); err != nil {
+		return nil, fmt.Errorf("invalid asciicast header: %w", err)
+	}
+	if a.Header.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version %d", a.Header.Version)
+	}
+	return a, nil
+}
+
+// Next returns the next event, io.EOF at the end of the recording.
+func (a *AsciicastReader) Next() (*AsciicastEvent, error) {
+	for a.s.Scan() {
+		if len(a.s.Bytes()) == 0 {
+			continue
+		}
+		var e AsciicastEvent
+		if err := json.Unmarshal(a.s.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("invalid asciicast event: %w", err)
+		}
+		return &e, nil
+	}
+	if err := a.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
@@ -0,0 +1,197 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cio
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBroadcastQueue is the number of writes a client of a Broadcaster may
+// fall behind by before it is dropped.
+const DefaultBroadcastQueue = 256
+
+// Broadcaster is an io.Writer copying what is written to it to any number of
+// clients. Every client has a bounded queue drained by its own goroutine: a
+// client whose queue is full is dropped and closed, so that a slow client
+// holds up neither the others nor the writer.
+type Broadcaster struct {
+	mu        sync.Mutex
+	clients   map[*broadcastClient]struct{}
+	queueSize int
+	closed    bool
+}
+
+type broadcastClient struct {
+	w     io.WriteCloser
+	queue chan *broadcastChunk
+	// done is closed once the client stopped
+	done chan struct{}
+}
+
+// broadcastChunk is the data of a write, shared by the clients and put back
+// into bufPool by the last one done with it.
+type broadcastChunk struct {
+	data []byte
+	buf  *[]byte
+	refs int32
+}
+
+func (c *broadcastChunk) release() {
+	if atomic.AddInt32(&c.refs, -1) == 0 && c.buf != nil {
+		bufPool.Put(c.buf)
+	}
+}
+
+// NewBroadcaster returns a Broadcaster whose clients may fall behind by
+// queueSize writes, DefaultBroadcastQueue when zero or less.
+func NewBroadcaster(queueSize int) *Broadcaster {
+	if queueSize <= 0 {
+		queueSize = DefaultBroadcastQueue
+	}
+	return &Broadcaster{
+		clients:   make(map[*broadcastClient]struct{}),
+		queueSize: queueSize,
+	}
+}
+
+// Add writes greeting to w, unless it is empty, then copies what is written
+// from now on to w, until writing to w fails, w falls behind, Remove is called
+// or the Broadcaster is closed, which all close w. The returned channel is
+// closed once w is closed.
+func (b *Broadcaster) Add(w io.WriteCloser, greeting []byte) (<-chan struct{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, errors.New("broadcaster is closed")
+	}
+	c := &broadcastClient{
+		w:     w,
+		queue: make(chan *broadcastChunk, b.queueSize),
+		done:  make(chan struct{}),
+	}
+	if len(greeting) > 0 {
+		c.queue <- &broadcastChunk{data: greeting, refs: 1}
+	}
+	b.clients[c] = struct{}{}
+	go b.drain(c)
+	return c.done, nil
+}
+
+// Send queues p for w alone, in order with what is written to all clients.
+func (b *Broadcaster) Send(w io.WriteCloser, p []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		if c.w != w {
+			continue
+		}
+		select {
+		case c.queue <- &broadcastChunk{data: append([]byte(nil), p...), refs: 1}:
+		default:
+			b.drop(c)
+		}
+	}
+}
+
+// Remove stops copying to w and closes it.
+func (b *Broadcaster) Remove(w io.WriteCloser) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		if c.w == w {
+			b.drop(c)
+		}
+	}
+}
+
+// Len returns the number of clients.
+func (b *Broadcaster) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.clients)
+}
+
+// Write queues a copy of p for every client. It never blocks on a client and
+// never fails.
+func (b *Broadcaster) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.clients) == 0 {
+		return len(p), nil
+	}
+	chunk := &broadcastChunk{refs: 1}
+	if buf := bufPool.Get().(*[]byte); len(p) <= len(*buf) {
+		chunk.buf = buf
+		chunk.data = (*buf)[:len(p)]
+	} else {
+		bufPool.Put(buf)
+		chunk.data = make([]byte, len(p))
+	}
+	copy(chunk.data, p)
+	for c := range b.clients {
+		atomic.AddInt32(&chunk.refs, 1)
+		select {
+		case c.queue <- chunk:
+		default:
+			chunk.release()
+			b.drop(c)
+		}
+	}
+	chunk.release()
+	return len(p), nil
+}
+
+// Close drops all clients and refuses new ones.
+func (b *Broadcaster) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for c := range b.clients {
+		b.drop(c)
+	}
+	return nil
+}
+
+// drop removes a client, whose drain closes it. It is called with mu held.
+func (b *Broadcaster) drop(c *broadcastClient) {
+	if _, ok := b.clients[c]; !ok {
+		return
+	}
+	delete(b.clients, c)
+	close(c.queue)
+	// Unblock a write stuck on a client that stopped reading.
+	c.w.Close()
+}
+
+func (b *Broadcaster) drain(c *broadcastClient) {
+	defer close(c.done)
+	failed := false
+	for chunk := range c.queue {
+		if !failed {
+			if _, err := c.w.Write(chunk.data); err != nil {
+				failed = true
+				b.mu.Lock()
+				b.drop(c)
+				b.mu.Unlock()
+			}
+		}
+		chunk.release()
+	}
+}
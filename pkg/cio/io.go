@@ -60,6 +60,11 @@ type IO interface {
 	// Close cleans up all open io resources. Cancel() is always called before
 	// Close()
 	Close() error
+	// Err returns the first error a stream was copied with, other than its
+	// end or the cancellation of the IO. It is nil after a clean end.
+	Err() error
+	// Stats counts what went through the streams so far.
+	Stats() Stats
 }
 
 // Creator creates new IO sets for a task
@@ -182,12 +187,21 @@ type cio struct {
 	wg      *sync.WaitGroup
 	closers []io.Closer
 	cancel  context.CancelFunc
+	stats   *ioStats
 }
 
 func (c *cio) Config() Config {
 	return c.config
 }
 
+func (c *cio) Err() error {
+	return c.stats.Err()
+}
+
+func (c *cio) Stats() Stats {
+	return c.stats.Stats()
+}
+
 func (c *cio) Wait() {
 	if c.wg != nil {
 		c.wg.Wait()
@@ -343,6 +357,16 @@ func (l *logURI) Close() error {
 	return nil
 }
 
+// Err returns nil, the shim copies the streams.
+func (l *logURI) Err() error {
+	return nil
+}
+
+// Stats returns nothing, the shim copies the streams.
+func (l *logURI) Stats() Stats {
+	return Stats{}
+}
+
 // Load the io for a container but do not attach
 //
 // Allows io to be loaded on the task for deletion without
@@ -60,13 +60,15 @@ func copyIO(fifos *FIFOSet, ioset *Streams) (*cio, error) {
 		cancel()
 		return nil, err
 	}
+	stats := newIOStats(ctx)
 
 	if fifos.Stdin != "" {
 		go func() {
 			p := bufPool.Get().(*[]byte)
 			defer bufPool.Put(p)
 
-			io.CopyBuffer(pipes.Stdin, ioset.Stdin, *p)
+			_, err := io.CopyBuffer(countingWriter{pipes.Stdin, &stats.stdin}, ioset.Stdin, *p)
+			stats.fail("stdin", err)
 			pipes.Stdin.Close()
 		}()
 	}
@@ -78,7 +80,8 @@ func copyIO(fifos *FIFOSet, ioset *Streams) (*cio, error) {
 			p := bufPool.Get().(*[]byte)
 			defer bufPool.Put(p)
 
-			io.CopyBuffer(ioset.Stdout, pipes.Stdout, *p)
+			_, err := io.CopyBuffer(countingWriter{ioset.Stdout, &stats.stdout}, pipes.Stdout, *p)
+			stats.fail("stdout", err)
 			pipes.Stdout.Close()
 			wg.Done()
 		}()
@@ -90,7 +93,8 @@ func copyIO(fifos *FIFOSet, ioset *Streams) (*cio, error) {
 			p := bufPool.Get().(*[]byte)
 			defer bufPool.Put(p)
 
-			io.CopyBuffer(ioset.Stderr, pipes.Stderr, *p)
+			_, err := io.CopyBuffer(countingWriter{ioset.Stderr, &stats.stderr}, pipes.Stderr, *p)
+			stats.fail("stderr", err)
 			pipes.Stderr.Close()
 			wg.Done()
 		}()
@@ -100,6 +104,7 @@ func copyIO(fifos *FIFOSet, ioset *Streams) (*cio, error) {
 		wg:      wg,
 		closers: append(pipes.closers(), fifos),
 		cancel:  cancel,
+		stats:   stats,
 	}, nil
 }
 
@@ -143,12 +148,25 @@ func openFifos(ctx context.Context, fifos *FIFOSet) (f pipes, retErr error) {
 func NewDirectIO(ctx context.Context, fifos *FIFOSet) (*DirectIO, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	pipes, err := openFifos(ctx, fifos)
+	closers := append(pipes.closers(), fifos)
+	// The caller copies the streams, through pipes counting them.
+	stats := newIOStats(ctx)
+	if pipes.Stdin != nil {
+		pipes.Stdin = &countingStdin{WriteCloser: pipes.Stdin, c: &stats.stdin, stats: stats}
+	}
+	if pipes.Stdout != nil {
+		pipes.Stdout = &countingPipe{ReadCloser: pipes.Stdout, stream: "stdout", c: &stats.stdout, stats: stats}
+	}
+	if pipes.Stderr != nil {
+		pipes.Stderr = &countingPipe{ReadCloser: pipes.Stderr, stream: "stderr", c: &stats.stderr, stats: stats}
+	}
 	return &DirectIO{
 		pipes: pipes,
 		cio: cio{
 			config:  fifos.Config,
-			closers: append(pipes.closers(), fifos),
+			closers: closers,
 			cancel:  cancel,
+			stats:   stats,
 		},
 	}, err
 }
@@ -0,0 +1,283 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cio
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// LogFormat is the format the output of a container is written to its log in.
+type LogFormat string
+
+const (
+	// LogFormatRaw writes the output as it is
+	LogFormatRaw LogFormat = "raw"
+	// LogFormatCRI writes a line "<timestamp> <stream> <P|F> <message>" per
+	// line of output, as the Kubernetes CRI does
+	LogFormatCRI LogFormat = "cri"
+	// LogFormatJSON writes a JSON object {"log","stream","time"} per line of
+	// output, as the json-file log driver of docker does
+	LogFormatJSON LogFormat = "json"
+)
+
+// Names of the streams in structured logs.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// maxLogLineSize is the size at which a line without newline is written as a
+// partial record.
+const maxLogLineSize = 16 << 10
+
+// ParseLogFormat parses the name of a log format, raw when empty.
+func ParseLogFormat(s string) (LogFormat, error) {
+	switch f := LogFormat(s); f {
+	case "":
+		return LogFormatRaw, nil
+	case LogFormatRaw, LogFormatCRI, LogFormatJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown log format %q, expected raw, cri or json", s)
+	}
+}
+
+// LogWriter frames the output of the streams of a container into records of
+// a log format and writes them to one log.
+type LogWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format LogFormat
+	// now returns the timestamp of a record
+	now func() time.Time
+}
+
+// NewLogWriter returns a LogWriter writing records of format to w.
+func NewLogWriter(w io.Writer, format LogFormat) *LogWriter {
+	return &LogWriter{
+		w:      w,
+		format: format,
+		now:    time.Now,
+	}
+}
+
+// Stream returns the writer of one stream. It buffers a line until its
+// newline, or until it grows too long and is written as a partial record.
+// Closing it writes what is left of the last line.
+func (l *LogWriter) Stream(stream string) io.WriteCloser {
+	if l.format == LogFormatRaw {
+		return rawStreamWriter{l: l}
+	}
+	// A JSON record keeps telling that the newline of the last line is
+	// missing.
+	return NewLineWriter(func(line []byte, partial bool) error {
+		return l.write(stream, line, partial)
+	}, l.format == LogFormatJSON)
+}
+
+func (l *LogWriter) write(stream string, line []byte, partial bool) error {
+	var buf []byte
+	ts := l.now().UTC()
+	switch l.format {
+	case LogFormatCRI:
+		tag := "F"
+		if partial {
+			tag = "P"
+		}
+		buf = make([]byte, 0, len(line)+64)
+		buf = ts.AppendFormat(buf, time.RFC3339Nano)
+		buf = append(buf, ' ')
+		buf = append(buf, stream...)
+		buf = append(buf, ' ')
+		buf = append(buf, tag...)
+		buf = append(buf, ' ')
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	case LogFormatJSON:
+		log := string(line)
+		if !partial {
+			log += "\n"
+		}
+		data, err := json.Marshal(jsonLogRecord{Log: log, Stream: stream, Time: ts})
+		if err != nil {
+			return err
+		}
+		buf = append(data, '\n')
+	default:
+		return fmt.Errorf("log format %q has no records", l.format)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.w.Write(buf)
+	return err
+}
+
+type rawStreamWriter struct {
+	l *LogWriter
+}
+
+func (w rawStreamWriter) Write(p []byte) (int, error) {
+	w.l.mu.Lock()
+	defer w.l.mu.Unlock()
+	return w.l.w.Write(p)
+}
+
+func (w rawStreamWriter) Close() error {
+	return nil
+}
+
+// LineWriter splits what is written to it into lines, which it passes to emit
+// without their newline. A line that grows too long is passed on in partial
+// pieces.
+type LineWriter struct {
+	emit func(line []byte, partial bool) error
+	// closePartial passes what is left of the last line on close as
+	// partial, since it lost its newline
+	closePartial bool
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewLineWriter returns a LineWriter passing lines to emit. What is left of
+// the last line on close is passed on as partial when closePartial is set.
+func NewLineWriter(emit func(line []byte, partial bool) error, closePartial bool) *LineWriter {
+	return &LineWriter{emit: emit, closePartial: closePartial}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buf[:i]
+		for len(line) > maxLogLineSize {
+			if err := w.emit(line[:maxLogLineSize], true); err != nil {
+				return len(p), err
+			}
+			line = line[maxLogLineSize:]
+		}
+		if err := w.emit(line, false); err != nil {
+			return len(p), err
+		}
+		w.buf = w.buf[i+1:]
+	}
+	for len(w.buf) >= maxLogLineSize {
+		if err := w.emit(w.buf[:maxLogLineSize], true); err != nil {
+			return len(p), err
+		}
+		w.buf = w.buf[maxLogLineSize:]
+	}
+	// Keep the rest of the line in a buffer of its own, so that the
+	// buffer does not grow with the output that went through it.
+	w.buf = append([]byte(nil), w.buf...)
+	return len(p), nil
+}
+
+// Break passes on what is left of the current line as partial, so that what
+// is written next starts a line of its own. It is used when output is left
+// out in the middle of a line.
+func (w *LineWriter) Break() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.emit(w.buf, true)
+	w.buf = nil
+	return err
+}
+
+// Close passes on what is left of the last line.
+func (w *LineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.emit(w.buf, w.closePartial)
+	w.buf = nil
+	return err
+}
+
+type jsonLogRecord struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// LogRecord is a record of a structured container log.
+type LogRecord struct {
+	Time   time.Time
+	Stream string
+	// Partial is set when the line goes on in the next record of the stream
+	Partial bool
+	// Line is the output, without newline
+	Line []byte
+}
+
+// ParseLogRecord parses a line of a log written in format, without its
+// newline.
+func ParseLogRecord(format LogFormat, line []byte) (*LogRecord, error) {
+	switch format {
+	case LogFormatCRI:
+		fields := bytes.SplitN(line, []byte{' '}, 4)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid CRI log line %q", line)
+		}
+		ts, err := time.Parse(time.RFC3339Nano, string(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CRI log timestamp: %w", err)
+		}
+		r := &LogRecord{Time: ts, Stream: string(fields[1])}
+		switch string(fields[2]) {
+		case "P":
+			r.Partial = true
+		case "F":
+		default:
+			return nil, fmt.Errorf("invalid CRI log tag %q", fields[2])
+		}
+		if len(fields) == 4 {
+			r.Line = fields[3]
+		}
+		return r, nil
+	case LogFormatJSON:
+		var j jsonLogRecord
+		if err := json.Unmarshal(line, &j); err != nil {
+			return nil, fmt.Errorf("invalid JSON log line: %w", err)
+		}
+		r := &LogRecord{Time: j.Time, Stream: j.Stream, Line: []byte(j.Log)}
+		if bytes.HasSuffix(r.Line, []byte{'\n'}) {
+			r.Line = r.Line[:len(r.Line)-1]
+		} else {
+			r.Partial = true
+		}
+		return r, nil
+	default:
+		return nil, errors.New("raw logs have no records")
+	}
+}
@@ -0,0 +1,254 @@
+package cio
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type emitted struct {
+	line    string
+	partial bool
+}
+
+func TestLineWriter(t *testing.T) {
+	long := strings.Repeat("a", maxLogLineSize)
+	for _, tc := range []struct {
+		name         string
+		writes       []string
+		closePartial bool
+		// breakAfter calls Break after the write of that index
+		breakAfter int
+		want       []emitted
+	}{
+		{
+			name:       "lines",
+			writes:     []string{"one\ntwo\n"},
+			breakAfter: -1,
+			want:       []emitted{{"one", false}, {"two", false}},
+		},
+		{
+			name:       "line across writes",
+			writes:     []string{"o", "n", "e\nt", "wo\n"},
+			breakAfter: -1,
+			want:       []emitted{{"one", false}, {"two", false}},
+		},
+		{
+			name:       "empty line",
+			writes:     []string{"\n"},
+			breakAfter: -1,
+			want:       []emitted{{"", false}},
+		},
+		{
+			name:       "last line on close",
+			writes:     []string{"one\ntwo"},
+			breakAfter: -1,
+			want:       []emitted{{"one", false}, {"two", false}},
+		},
+		{
+			name:         "last line on close partial",
+			writes:       []string{"one\ntwo"},
+			closePartial: true,
+			breakAfter:   -1,
+			want:         []emitted{{"one", false}, {"two", true}},
+		},
+		{
+			name:       "long line split",
+			writes:     []string{long + long + "end\n"},
+			breakAfter: -1,
+			want:       []emitted{{long, true}, {long, true}, {"end", false}},
+		},
+		{
+			name:       "long line split across writes",
+			writes:     []string{long[:100], long[100:] + "b", "\n"},
+			breakAfter: -1,
+			want:       []emitted{{long, true}, {"b", false}},
+		},
+		{
+			name:       "line of exactly the maximum",
+			writes:     []string{long + "\n"},
+			breakAfter: -1,
+			want:       []emitted{{long, false}},
+		},
+		{
+			name:       "break",
+			writes:     []string{"before", "after\n"},
+			breakAfter: 0,
+			want:       []emitted{{"before", true}, {"after", false}},
+		},
+		{
+			name:       "break between lines",
+			writes:     []string{"one\n", "two\n"},
+			breakAfter: 0,
+			want:       []emitted{{"one", false}, {"two", false}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []emitted
+			w := NewLineWriter(func(line []byte, partial bool) error {
+				got = append(got, emitted{string(line), partial})
+				return nil
+			}, tc.closePartial)
+			for i, s := range tc.writes {
+				if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("write %d: %d, %v", i, n, err)
+				}
+				if i == tc.breakAfter {
+					if err := w.Break(); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseLogRecord(t *testing.T) {
+	ts := time.Date(2022, 6, 30, 14, 52, 36, 123456789, time.UTC)
+	for _, tc := range []struct {
+		name    string
+		format  LogFormat
+		line    string
+		want    *LogRecord
+		wantErr bool
+	}{
+		{
+			name:   "cri full",
+			format: LogFormatCRI,
+			line:   "2022-06-30T14:52:36.123456789Z stdout F hello world",
+			want:   &LogRecord{Time: ts, Stream: StreamStdout, Line: []byte("hello world")},
+		},
+		{
+			name:   "cri partial",
+			format: LogFormatCRI,
+			line:   "2022-06-30T14:52:36.123456789Z stderr P hello",
+			want:   &LogRecord{Time: ts, Stream: StreamStderr, Partial: true, Line: []byte("hello")},
+		},
+		{
+			name:   "cri empty line",
+			format: LogFormatCRI,
+			line:   "2022-06-30T14:52:36.123456789Z stdout F ",
+			want:   &LogRecord{Time: ts, Stream: StreamStdout, Line: []byte("")},
+		},
+		{
+			name:   "cri without message",
+			format: LogFormatCRI,
+			line:   "2022-06-30T14:52:36.123456789Z stdout F",
+			want:   &LogRecord{Time: ts, Stream: StreamStdout},
+		},
+		{
+			name:    "cri bad tag",
+			format:  LogFormatCRI,
+			line:    "2022-06-30T14:52:36.123456789Z stdout X hello",
+			wantErr: true,
+		},
+		{
+			name:    "cri bad timestamp",
+			format:  LogFormatCRI,
+			line:    "yesterday stdout F hello",
+			wantErr: true,
+		},
+		{
+			name:    "cri too short",
+			format:  LogFormatCRI,
+			line:    "2022-06-30T14:52:36.123456789Z",
+			wantErr: true,
+		},
+		{
+			name:   "json full",
+			format: LogFormatJSON,
+			line:   `{"log":"hello\n","stream":"stdout","time":"2022-06-30T14:52:36.123456789Z"}`,
+			want:   &LogRecord{Time: ts, Stream: StreamStdout, Line: []byte("hello")},
+		},
+		{
+			name:   "json partial",
+			format: LogFormatJSON,
+			line:   `{"log":"hel","stream":"stderr","time":"2022-06-30T14:52:36.123456789Z"}`,
+			want:   &LogRecord{Time: ts, Stream: StreamStderr, Partial: true, Line: []byte("hel")},
+		},
+		{
+			name:    "json invalid",
+			format:  LogFormatJSON,
+			line:    `{"log":`,
+			wantErr: true,
+		},
+		{
+			name:    "raw",
+			format:  LogFormatRaw,
+			line:    "hello",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseLogRecord(tc.format, []byte(tc.line))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("parsed %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Time.Equal(tc.want.Time) || got.Stream != tc.want.Stream || got.Partial != tc.want.Partial || !bytes.Equal(got.Line, tc.want.Line) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestLogWriterRecords checks that the records written in each format parse
+// back into the output.
+func TestLogWriterRecords(t *testing.T) {
+	ts := time.Date(2022, 6, 30, 14, 52, 36, 0, time.UTC)
+	long := strings.Repeat("b", maxLogLineSize)
+	for _, format := range []LogFormat{LogFormatCRI, LogFormatJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			l := NewLogWriter(&buf, format)
+			l.now = func() time.Time { return ts }
+			stdout, stderr := l.Stream(StreamStdout), l.Stream(StreamStderr)
+			stdout.Write([]byte("out\n" + long + "x\nla"))
+			stderr.Write([]byte("err\n"))
+			stdout.Write([]byte("st"))
+			stdout.Close()
+			stderr.Close()
+
+			var got []LogRecord
+			for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+				r, err := ParseLogRecord(format, []byte(line))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !r.Time.Equal(ts) {
+					t.Errorf("record time %s, want %s", r.Time, ts)
+				}
+				got = append(got, LogRecord{Stream: r.Stream, Partial: r.Partial, Line: r.Line})
+			}
+			want := []LogRecord{
+				{Stream: StreamStdout, Line: []byte("out")},
+				{Stream: StreamStdout, Partial: true, Line: []byte(long)},
+				{Stream: StreamStdout, Line: []byte("x")},
+				{Stream: StreamStderr, Line: []byte("err")},
+				// Only JSON tells that the newline is missing.
+				{Stream: StreamStdout, Partial: format == LogFormatJSON, Line: []byte("last")},
+			}
+			if len(got) != len(want) {
+				t.Fatalf("got %d records, want %d: %q", len(got), len(want), buf.String())
+			}
+			for i := range want {
+				if got[i].Stream != want[i].Stream || got[i].Partial != want[i].Partial || !bytes.Equal(got[i].Line, want[i].Line) {
+					t.Errorf("record %d: got %+v, want %+v", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
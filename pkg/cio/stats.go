@@ -0,0 +1,165 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StreamStats counts what went through a stream of an IO.
+type StreamStats struct {
+	// Bytes copied
+	Bytes uint64 `json:"bytes"`
+	// Chunks is the number of reads or writes that copied bytes
+	Chunks uint64 `json:"chunks"`
+	// LastActivity is the time bytes were last copied
+	LastActivity time.Time `json:"last_activity,omitempty"`
+}
+
+// Stats counts what went through the streams of an IO.
+type Stats struct {
+	Stdin  StreamStats `json:"stdin"`
+	Stdout StreamStats `json:"stdout"`
+	Stderr StreamStats `json:"stderr"`
+}
+
+// streamCounter counts a stream, safe for concurrent use.
+type streamCounter struct {
+	bytes  uint64
+	chunks uint64
+	// last is the time of the last activity in unix nanoseconds
+	last int64
+}
+
+func (c *streamCounter) add(n int) {
+	if n <= 0 {
+		return
+	}
+	atomic.AddUint64(&c.bytes, uint64(n))
+	atomic.AddUint64(&c.chunks, 1)
+	atomic.StoreInt64(&c.last, time.Now().UnixNano())
+}
+
+func (c *streamCounter) stats() StreamStats {
+	s := StreamStats{
+		Bytes:  atomic.LoadUint64(&c.bytes),
+		Chunks: atomic.LoadUint64(&c.chunks),
+	}
+	if last := atomic.LoadInt64(&c.last); last != 0 {
+		s.LastActivity = time.Unix(0, last)
+	}
+	return s
+}
+
+// ioStats holds the counters and the first copy error of an IO.
+type ioStats struct {
+	stdin, stdout, stderr streamCounter
+
+	// ctx is done once the IO is cancelled, after which copy errors are
+	// expected.
+	ctx context.Context
+
+	mu  sync.Mutex
+	err error
+}
+
+func newIOStats(ctx context.Context) *ioStats {
+	return &ioStats{ctx: ctx}
+}
+
+// fail records the error a stream was copied with, unless it is a clean end
+// or the IO was cancelled.
+func (s *ioStats) fail(stream string, err error) {
+	if err == nil || errors.Is(err, io.EOF) || errors.Is(err, os.ErrClosed) || s.ctx.Err() != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = fmt.Errorf("copy %s: %w", stream, err)
+	}
+}
+
+func (s *ioStats) Err() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *ioStats) Stats() Stats {
+	if s == nil {
+		return Stats{}
+	}
+	return Stats{
+		Stdin:  s.stdin.stats(),
+		Stdout: s.stdout.stats(),
+		Stderr: s.stderr.stats(),
+	}
+}
+
+// countingWriter counts the writes to a stream.
+type countingWriter struct {
+	io.Writer
+	c *streamCounter
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.c.add(n)
+	return n, err
+}
+
+// countingPipe counts the reads of a pipe of a DirectIO and records their
+// errors.
+type countingPipe struct {
+	io.ReadCloser
+	stream string
+	c      *streamCounter
+	stats  *ioStats
+}
+
+func (p *countingPipe) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	p.c.add(n)
+	p.stats.fail(p.stream, err)
+	return n, err
+}
+
+// countingStdin counts the writes to the stdin pipe of a DirectIO and
+// records their errors.
+type countingStdin struct {
+	io.WriteCloser
+	c     *streamCounter
+	stats *ioStats
+}
+
+func (p *countingStdin) Write(b []byte) (int, error) {
+	n, err := p.WriteCloser.Write(b)
+	p.c.add(n)
+	p.stats.fail("stdin", err)
+	return n, err
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// DefaultPath is where runs looks for its configuration file.
+const DefaultPath = "/etc/runs/config.json"
+
+// Config is the runs configuration file.
+type Config struct {
+	// Runtimes are the runtime handlers, keyed by handler name
+	Runtimes map[string]Runtime `json:"runtimes,omitempty"`
+	// Sinks receive the events shims publish
+	Sinks []Sink `json:"sinks,omitempty"`
+}
+
+// Sink configures where events are delivered.
+type Sink struct {
+	// Type is one of file, unix, unixgram, webhook or exec
+	Type string `json:"type"`
+	// Path is the file to append to, the socket to send to, or the command
+	// to run
+	Path string `json:"path,omitempty"`
+	// Args are the arguments of the exec command
+	Args []string `json:"args,omitempty"`
+	// URL is the webhook events are POSTed to
+	URL string `json:"url,omitempty"`
+	// Topics filters the events by topic, with shell patterns such as
+	// "/tasks/*"; empty delivers every event
+	Topics []string `json:"topics,omitempty"`
+	// Retries is the number of times a failed delivery is retried
+	Retries int `json:"retries,omitempty"`
+	// QueueSize bounds the events waiting for delivery, further events are
+	// dropped
+	QueueSize int `json:"queue_size,omitempty"`
+	// Timeout bounds a single delivery
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// Runtime configures a runtime handler.
+type Runtime struct {
+	// Type is the shim runtime name (e.g. io.containerd.kata.v2) or an
+	// absolute path to the shim binary
+	Type string `json:"runtime_type"`
+	// Options is the path to a JSON file with the typed shim options
+	Options string `json:"runtime_options,omitempty"`
+	// Timeouts override the default shim timeouts for the handler
+	Timeouts Timeouts `json:"timeouts,omitempty"`
+	// Pool keeps idle shims of the handler started ahead of time
+	Pool Pool `json:"pool,omitempty"`
+}
+
+// Pool configures the pool of pre-started shims of a runtime handler.
+type Pool struct {
+	// Size is the number of idle shims to keep, zero disables the pool
+	Size int `json:"size,omitempty"`
+	// IdleTTL is how long an idle shim is kept before it is replaced
+	IdleTTL Duration `json:"idle_ttl,omitempty"`
+	// Refill starts a replacement whenever a shim is taken from the pool
+	Refill bool `json:"refill,omitempty"`
+}
+
+// Timeouts are the shim timeouts; zero values keep the default.
+type Timeouts struct {
+	Load     Duration `json:"load,omitempty"`
+	Cleanup  Duration `json:"cleanup,omitempty"`
+	Shutdown Duration `json:"shutdown,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in JSON.
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Load reads the configuration file at path. A missing file yields an empty
+// configuration unless mustExist is set.
+func Load(path string, mustExist bool) (*Config, error) {
+	c := &Config{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !mustExist {
+			return c, nil
+		}
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Runtime returns the runtime handler with the given name, or the first
+// handler (by name) whose runtime type matches it.
+func (c *Config) Runtime(name string) (Runtime, bool) {
+	if r, ok := c.Runtimes[name]; ok {
+		return r, true
+	}
+	names := make([]string, 0, len(c.Runtimes))
+	for n := range c.Runtimes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if r := c.Runtimes[n]; r.Type == name {
+			return r, true
+		}
+	}
+	return Runtime{}, false
+}
@@ -0,0 +1,73 @@
+package logdriver
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/kata-contrib/runs/pkg/cio"
+)
+
+// journaldSocket is the native protocol socket of systemd-journald.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// Priorities of the lines of each stream, as syslog levels.
+const (
+	priorityInfo = 6
+	priorityErr  = 3
+)
+
+// journald sends a datagram of journal fields per line.
+type journald struct {
+	info Info
+	conn *conn
+}
+
+func newJournald(address string, info Info) *journald {
+	if address == "" {
+		address = journaldSocket
+	}
+	return &journald{
+		info: info,
+		conn: &conn{network: "unixgram", address: address},
+	}
+}
+
+func (j *journald) Log(stream string, line []byte, partial bool) error {
+	priority := priorityInfo
+	if stream == cio.StreamStderr {
+		priority = priorityErr
+	}
+	var buf bytes.Buffer
+	appendJournalField(&buf, "MESSAGE", line)
+	appendJournalField(&buf, "CONTAINER_ID", []byte(j.info.ID))
+	appendJournalField(&buf, "CONTAINER_NAME", []byte(j.info.Name))
+	appendJournalField(&buf, "PRIORITY", []byte{'0' + byte(priority)})
+	appendJournalField(&buf, "SYSLOG_IDENTIFIER", []byte(j.info.Name))
+	if partial {
+		appendJournalField(&buf, "CONTAINER_PARTIAL_MESSAGE", []byte("true"))
+	}
+	return j.conn.write(buf.Bytes())
+}
+
+func (j *journald) Close() error {
+	return j.conn.close()
+}
+
+// appendJournalField appends a field in the native journal protocol: KEY=value
+// and a newline, or the key, a newline and the value prefixed with its 64-bit
+// little endian size when the value holds newlines.
+func appendJournalField(buf *bytes.Buffer, key string, value []byte) {
+	buf.WriteString(key)
+	if bytes.IndexByte(value, '\n') < 0 {
+		buf.WriteByte('=')
+		buf.Write(value)
+		buf.WriteByte('\n')
+		return
+	}
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	buf.WriteByte('\n')
+	buf.Write(size[:])
+	buf.Write(value)
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,140 @@
+package logdriver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// listenUnixgram listens on a stand-in socket in a temporary directory.
+func listenUnixgram(t *testing.T) (*net.UnixConn, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, path
+}
+
+func readDatagram(t *testing.T, conn net.Conn) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64<<10)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+// parseJournalFields parses a datagram of the native journal protocol.
+func parseJournalFields(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		i := bytes.IndexAny(data, "=\n")
+		if i < 0 {
+			t.Fatalf("truncated field %q", data)
+		}
+		key := string(data[:i])
+		if data[i] == '=' {
+			end := bytes.IndexByte(data[i:], '\n')
+			if end < 0 {
+				t.Fatalf("unterminated field %s", key)
+			}
+			fields[key] = string(data[i+1 : i+end])
+			data = data[i+end+1:]
+			continue
+		}
+		data = data[i+1:]
+		if len(data) < 8 {
+			t.Fatalf("field %s has no size", key)
+		}
+		size := binary.LittleEndian.Uint64(data)
+		data = data[8:]
+		if uint64(len(data)) < size+1 || data[size] != '\n' {
+			t.Fatalf("field %s has a bad size %d", key, size)
+		}
+		fields[key] = string(data[:size])
+		data = data[size+1:]
+	}
+	return fields
+}
+
+func TestJournald(t *testing.T) {
+	conn, path := listenUnixgram(t)
+	d, err := New(Journald, path, Info{ID: "c1", Name: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for _, tc := range []struct {
+		stream  string
+		line    string
+		partial bool
+		want    map[string]string
+	}{
+		{
+			stream: "stdout",
+			line:   "hello",
+			want: map[string]string{
+				"MESSAGE":           "hello",
+				"CONTAINER_ID":      "c1",
+				"CONTAINER_NAME":    "web",
+				"PRIORITY":          "6",
+				"SYSLOG_IDENTIFIER": "web",
+			},
+		},
+		{
+			stream:  "stderr",
+			line:    "first\nsecond",
+			partial: true,
+			want: map[string]string{
+				"MESSAGE":                   "first\nsecond",
+				"CONTAINER_ID":              "c1",
+				"CONTAINER_NAME":            "web",
+				"PRIORITY":                  "3",
+				"SYSLOG_IDENTIFIER":         "web",
+				"CONTAINER_PARTIAL_MESSAGE": "true",
+			},
+		},
+	} {
+		if err := d.Log(tc.stream, []byte(tc.line), tc.partial); err != nil {
+			t.Fatal(err)
+		}
+		data := readDatagram(t, conn)
+		if tc.partial && !bytes.Contains(data, []byte("MESSAGE\n")) {
+			t.Errorf("multi-line message not sent with its size: %q", data)
+		}
+		got := parseJournalFields(t, data)
+		if len(got) != len(tc.want) {
+			t.Errorf("fields = %q, want %q", got, tc.want)
+		}
+		for k, v := range tc.want {
+			if got[k] != v {
+				t.Errorf("%s = %q, want %q", k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestJournaldNameDefaultsToID(t *testing.T) {
+	conn, path := listenUnixgram(t)
+	d, err := New(Journald, path, Info{ID: "c1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.Log("stdout", []byte("x"), false); err != nil {
+		t.Fatal(err)
+	}
+	if got := parseJournalFields(t, readDatagram(t, conn))["CONTAINER_NAME"]; got != "c1" {
+		t.Errorf("CONTAINER_NAME = %q, want c1", got)
+	}
+}
@@ -0,0 +1,104 @@
+package logdriver
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Names of the log drivers.
+const (
+	// File writes the output to the log file of the monitor
+	File = "file"
+	// Journald sends a journal entry per line to the native journald socket
+	Journald = "journald"
+	// Syslog sends an RFC 5424 message per line to a syslog socket
+	Syslog = "syslog"
+)
+
+// writeTimeout bounds how long a line waits for a busy socket.
+const writeTimeout = 5 * time.Second
+
+// Driver sends the lines of output of a container to a logging system.
+type Driver interface {
+	// Log sends a line of stream, without its newline. Partial is set when
+	// the line goes on in the next call.
+	Log(stream string, line []byte, partial bool) error
+	Close() error
+}
+
+// Info describes the container a driver logs for.
+type Info struct {
+	ID   string
+	Name string
+}
+
+// ParseName checks the name of a log driver, file when empty.
+func ParseName(s string) (string, error) {
+	switch s {
+	case "":
+		return File, nil
+	case File, Journald, Syslog:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown log driver %q, expected file, journald or syslog", s)
+	}
+}
+
+// New returns the driver called name, sending to address or to the default
+// socket of the driver when empty.
+func New(name, address string, info Info) (Driver, error) {
+	if info.Name == "" {
+		info.Name = info.ID
+	}
+	switch name {
+	case Journald:
+		return newJournald(address, info), nil
+	case Syslog:
+		return newSyslog(address, info)
+	default:
+		return nil, fmt.Errorf("log driver %q sends no lines", name)
+	}
+}
+
+// conn is a socket dialed on first use and redialed once when a write to it
+// fails, e.g. after the logging daemon restarted.
+type conn struct {
+	network string
+	address string
+
+	mu sync.Mutex
+	c  net.Conn
+}
+
+func (c *conn) write(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if c.c == nil {
+			if c.c, err = net.DialTimeout(c.network, c.address, writeTimeout); err != nil {
+				continue
+			}
+		}
+		c.c.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err = c.c.Write(data); err == nil {
+			return nil
+		}
+		c.c.Close()
+		c.c = nil
+	}
+	return err
+}
+
+func (c *conn) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.c == nil {
+		return nil
+	}
+	err := c.c.Close()
+	c.c = nil
+	return err
+}
@@ -0,0 +1,110 @@
+package logdriver
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kata-contrib/runs/pkg/cio"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultQueueSize is the number of lines waiting for a slow socket.
+	defaultQueueSize = 1024
+	// drainTimeout bounds how long Close waits for the queued lines.
+	drainTimeout = 5 * time.Second
+)
+
+type queuedLine struct {
+	stream  string
+	line    []byte
+	partial bool
+}
+
+// Queue sends lines to a driver from a goroutine of its own, so that the
+// output of a container never waits on the socket of a logging daemon. Lines
+// arriving while the queue is full are dropped and counted.
+type Queue struct {
+	driver Driver
+	lines  chan queuedLine
+	done   chan struct{}
+	// dropped counts the bytes of stdout and stderr left out
+	dropped [2]uint64
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewQueue starts sending the lines queued for driver, at most size of them
+// waiting, or defaultQueueSize when size is zero or less.
+func NewQueue(driver Driver, size int) *Queue {
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	q := &Queue{
+		driver: driver,
+		lines:  make(chan queuedLine, size),
+		done:   make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Log queues a line without waiting, dropping it when the queue is full.
+func (q *Queue) Log(stream string, line []byte, partial bool) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return nil
+	}
+	select {
+	case q.lines <- queuedLine{stream: stream, line: append([]byte(nil), line...), partial: partial}:
+	default:
+		i := 0
+		if stream == cio.StreamStderr {
+			i = 1
+		}
+		atomic.AddUint64(&q.dropped[i], uint64(len(line)))
+	}
+	return nil
+}
+
+// Dropped returns the bytes of stdout and stderr left out because the queue
+// was full.
+func (q *Queue) Dropped() (stdout, stderr uint64) {
+	return atomic.LoadUint64(&q.dropped[0]), atomic.LoadUint64(&q.dropped[1])
+}
+
+// Close stops accepting lines and waits for the queued ones to be sent, for
+// drainTimeout at most. The driver is closed once they are.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.lines)
+	}
+	q.mu.Unlock()
+	select {
+	case <-q.done:
+	case <-time.After(drainTimeout):
+		logrus.Warn("log driver did not take the queued lines in time")
+	}
+	return nil
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+	defer q.driver.Close()
+	var failing bool
+	for l := range q.lines {
+		err := q.driver.Log(l.stream, l.line, l.partial)
+		// Report a failing socket once, not for every line.
+		if err != nil && !failing {
+			logrus.WithError(err).Warn("failed to send container log")
+		} else if err == nil && failing {
+			logrus.Info("log driver sends the container log again")
+		}
+		failing = err != nil
+	}
+}
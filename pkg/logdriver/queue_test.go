@@ -0,0 +1,62 @@
+package logdriver
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockedDriver blocks every line until it is released.
+type blockedDriver struct {
+	release chan struct{}
+
+	mu    sync.Mutex
+	lines []string
+}
+
+func (d *blockedDriver) Log(stream string, line []byte, partial bool) error {
+	<-d.release
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lines = append(d.lines, string(line))
+	return nil
+}
+
+func (d *blockedDriver) Close() error {
+	return nil
+}
+
+func TestQueueDropsWhenFull(t *testing.T) {
+	d := &blockedDriver{release: make(chan struct{})}
+	q := NewQueue(d, 2)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// One line is taken by the blocked driver, two wait in the queue
+		// and the rest is dropped.
+		for i := 0; i < 10; i++ {
+			q.Log("stdout", []byte("0123456789"), false)
+		}
+		q.Log("stderr", []byte("err"), false)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Log waited on a blocked driver")
+	}
+	stdout, stderr := q.Dropped()
+	if stdout < 70 || stdout > 80 || stderr != 3 {
+		t.Errorf("dropped stdout %d, stderr %d; want 70-80 and 3", stdout, stderr)
+	}
+
+	close(d.release)
+	q.Close()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if want := int(100-stdout) / 10; len(d.lines) != want {
+		t.Errorf("sent %d lines, want %d", len(d.lines), want)
+	}
+	// Lines after Close are dropped without panicking.
+	q.Log("stdout", []byte("late"), false)
+}
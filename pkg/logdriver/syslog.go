@@ -0,0 +1,128 @@
+package logdriver
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kata-contrib/runs/pkg/cio"
+)
+
+// syslogSocket is the local syslog socket.
+const syslogSocket = "/dev/log"
+
+const (
+	// facilityDaemon is the syslog facility of container output
+	facilityDaemon = 3
+	// maxAppName is the longest APP-NAME of RFC 5424
+	maxAppName = 48
+)
+
+// syslog sends an RFC 5424 message per line: a datagram, or a message framed
+// with its length (RFC 6587 octet counting) on a stream. The stream is the
+// MSGID; a partial line is sent as a message of its own.
+type syslog struct {
+	info     Info
+	hostname string
+	appName  string
+	stream   bool
+	conn     *conn
+}
+
+// newSyslog returns a syslog driver sending to address: a socket path, or a
+// unix://, unixgram://, tcp:// or udp:// URL.
+func newSyslog(address string, info Info) (*syslog, error) {
+	network, addr, err := parseSyslogAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &syslog{
+		info:     info,
+		hostname: hostname,
+		appName:  syslogAppName(info.Name),
+		stream:   network == "unix" || network == "tcp",
+		conn:     &conn{network: network, address: addr},
+	}, nil
+}
+
+func parseSyslogAddress(address string) (network, addr string, err error) {
+	if address == "" {
+		return "unixgram", syslogSocket, nil
+	}
+	if !strings.Contains(address, "://") {
+		return "unixgram", address, nil
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid syslog address %q: %w", address, err)
+	}
+	switch u.Scheme {
+	case "unix", "unixgram":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("syslog address %q has no socket path", address)
+		}
+		return u.Scheme, u.Path, nil
+	case "tcp", "udp":
+		if u.Host == "" {
+			return "", "", fmt.Errorf("syslog address %q has no host", address)
+		}
+		return u.Scheme, u.Host, nil
+	default:
+		return "", "", fmt.Errorf("unsupported syslog address %q, expected a socket path or a unix, unixgram, tcp or udp URL", address)
+	}
+}
+
+// syslogAppName returns the container name as an APP-NAME: printable ASCII
+// without spaces, at most maxAppName long.
+func syslogAppName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if c <= ' ' || c > '~' {
+			b[i] = '_'
+		}
+	}
+	if len(b) > maxAppName {
+		b = b[:maxAppName]
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+func (s *syslog) Log(stream string, line []byte, partial bool) error {
+	severity := priorityInfo
+	if stream == cio.StreamStderr {
+		severity = priorityErr
+	}
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	msg := make([]byte, 0, len(line)+128)
+	msg = append(msg, '<')
+	msg = strconv.AppendInt(msg, int64(facilityDaemon*8+severity), 10)
+	msg = append(msg, ">1 "...)
+	msg = time.Now().UTC().AppendFormat(msg, "2006-01-02T15:04:05.000000Z07:00")
+	msg = append(msg, ' ')
+	msg = append(msg, s.hostname...)
+	msg = append(msg, ' ')
+	msg = append(msg, s.appName...)
+	msg = append(msg, " - "...)
+	msg = append(msg, stream...)
+	msg = append(msg, " - "...)
+	msg = append(msg, line...)
+	if s.stream {
+		framed := strconv.AppendInt(make([]byte, 0, len(msg)+8), int64(len(msg)), 10)
+		msg = append(append(framed, ' '), msg...)
+	}
+	return s.conn.write(msg)
+}
+
+func (s *syslog) Close() error {
+	return s.conn.close()
+}
@@ -0,0 +1,131 @@
+package logdriver
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc5424RE matches <PRI>1 TIMESTAMP HOSTNAME APP-NAME - MSGID - MSG.
+var rfc5424RE = regexp.MustCompile(`^<(\d+)>1 (\S+) (\S+) (\S+) - (\S+) - (.*)$`)
+
+func checkSyslogMessage(t *testing.T, msg, wantPri, wantApp, wantMsgID, wantMsg string) {
+	t.Helper()
+	m := rfc5424RE.FindStringSubmatch(msg)
+	if m == nil {
+		t.Fatalf("%q is not an RFC 5424 message", msg)
+	}
+	if m[1] != wantPri {
+		t.Errorf("PRI = %s, want %s", m[1], wantPri)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, m[2]); err != nil {
+		t.Errorf("TIMESTAMP %q: %v", m[2], err)
+	}
+	if m[4] != wantApp {
+		t.Errorf("APP-NAME = %q, want %q", m[4], wantApp)
+	}
+	if m[5] != wantMsgID {
+		t.Errorf("MSGID = %q, want %q", m[5], wantMsgID)
+	}
+	if m[6] != wantMsg {
+		t.Errorf("MSG = %q, want %q", m[6], wantMsg)
+	}
+}
+
+func TestSyslogDatagram(t *testing.T) {
+	conn, path := listenUnixgram(t)
+	for _, address := range []string{path, "unixgram://" + path} {
+		d, err := New(Syslog, address, Info{ID: "c1", Name: "web server"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Log("stdout", []byte("hello"), false); err != nil {
+			t.Fatal(err)
+		}
+		// daemon.info
+		checkSyslogMessage(t, string(readDatagram(t, conn)), "30", "web_server", "stdout", "hello")
+		if err := d.Log("stderr", []byte("oops"), false); err != nil {
+			t.Fatal(err)
+		}
+		// daemon.err
+		checkSyslogMessage(t, string(readDatagram(t, conn)), "27", "web_server", "stderr", "oops")
+		d.Close()
+	}
+}
+
+// readOctetCounted reads a message framed as "LEN SP MSG".
+func readOctetCounted(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	size, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(size, " "))
+	if err != nil {
+		t.Fatalf("bad frame length %q", size)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestSyslogStream(t *testing.T) {
+	unixPath := filepath.Join(t.TempDir(), "sock")
+	for _, tc := range []struct {
+		network, address string
+		url              func(net.Addr) string
+	}{
+		{"unix", unixPath, func(net.Addr) string { return "unix://" + unixPath }},
+		{"tcp", "127.0.0.1:0", func(a net.Addr) string { return "tcp://" + a.String() }},
+	} {
+		t.Run(tc.network, func(t *testing.T) {
+			l, err := net.Listen(tc.network, tc.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			d, err := New(Syslog, tc.url(l.Addr()), Info{ID: "c1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+
+			// The first line dials the socket.
+			errc := make(chan error, 1)
+			go func() {
+				errc <- d.Log("stdout", []byte("one two"), false)
+			}()
+			conn, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Log("stderr", []byte("three"), false); err != nil {
+				t.Fatal(err)
+			}
+			r := bufio.NewReader(conn)
+			checkSyslogMessage(t, readOctetCounted(t, r), "30", "c1", "stdout", "one two")
+			checkSyslogMessage(t, readOctetCounted(t, r), "27", "c1", "stderr", "three")
+		})
+	}
+}
+
+func TestSyslogAddress(t *testing.T) {
+	for _, address := range []string{"http://host", "tcp://", "unix://"} {
+		if _, err := New(Syslog, address, Info{ID: "c1"}); err == nil {
+			t.Errorf("address %q was accepted", address)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package monitor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Streams of the frames exchanged on an attach connection.
+const (
+	Stdin  byte = 0
+	Stdout byte = 1
+	Stderr byte = 2
+	// Control frames set up the connection and tell about stdin
+	Control byte = 3
+)
+
+// StdinMode is what an attach client asks for the stdin of the container,
+// which one client at a time writes to.
+type StdinMode byte
+
+const (
+	// NoStdin only reads the output
+	NoStdin StdinMode = 0
+	// WantStdin writes to stdin unless another client does
+	WantStdin StdinMode = 1
+	// TakeStdin takes stdin over from the client writing to it
+	TakeStdin StdinMode = 2
+)
+
+// Control messages of the monitor, the data of its Control frames.
+const (
+	// ctlAttached acknowledges an attach without stdin
+	ctlAttached byte = 0
+	// ctlStdinGranted acknowledges an attach with stdin
+	ctlStdinGranted byte = 1
+	// ctlStdinTaken tells that another client took stdin over
+	ctlStdinTaken byte = 2
+)
+
+const (
+	// frameHeaderSize is the size of the header preceding the data of a
+	// frame.
+	frameHeaderSize = 5
+	// maxFrameSize bounds the data of a single frame.
+	maxFrameSize = 1 << 20
+)
+
+// putFrameHeader fills the header of a frame of size bytes of data.
+func putFrameHeader(buf []byte, stream byte, size int) {
+	buf[0] = stream
+	binary.BigEndian.PutUint32(buf[1:frameHeaderSize], uint32(size))
+}
+
+// WriteFrame writes data to an attach connection as a frame of the given
+// stream: the stream byte, the length of the data as a big endian uint32,
+// then the data. An empty stdin frame closes the stdin of the container.
+func WriteFrame(w io.Writer, stream byte, data []byte) error {
+	buf := make([]byte, frameHeaderSize+len(data))
+	putFrameHeader(buf, stream, len(data))
+	copy(buf[frameHeaderSize:], data)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadFrame reads the next frame of an attach connection.
+func ReadFrame(r io.Reader) (stream byte, data []byte, err error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:frameHeaderSize])
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes exceeds the limit of %d", size, maxFrameSize)
+	}
+	data = make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return header[0], data, nil
+}
+
+// Conn is an attach connection to the monitor of a container.
+type Conn struct {
+	conn net.Conn
+	// stdin is set while the connection writes to the stdin of the
+	// container
+	stdin atomic.Bool
+}
+
+// Dial connects to the monitor listening on socket, asking for stdin as mode
+// tells. It returns once the monitor copies the output of the container to
+// the connection, which it acknowledges with a control frame.
+func Dial(ctx context.Context, socket string, mode StdinMode) (*Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to monitor: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := WriteFrame(conn, Control, []byte{byte(mode)}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to attach: %w", err)
+	}
+	stream, data, err := ReadFrame(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("monitor did not accept attach: %w", err)
+	}
+	if stream != Control || len(data) != 1 {
+		conn.Close()
+		return nil, errors.New("monitor did not acknowledge attach")
+	}
+	conn.SetDeadline(time.Time{})
+	c := &Conn{conn: conn}
+	c.stdin.Store(data[0] == ctlStdinGranted)
+	return c, nil
+}
+
+// HasStdin tells whether the connection writes to the stdin of the container.
+func (c *Conn) HasStdin() bool {
+	return c.stdin.Load()
+}
+
+// Attach connects to the monitor listening on socket and copies its IO, see
+// Conn.Copy. It asks for stdin as mode tells, unless stdin is nil.
+func Attach(ctx context.Context, socket string, mode StdinMode, stdin io.Reader, stdout, stderr io.Writer) error {
+	if stdin == nil {
+		mode = NoStdin
+	}
+	c, err := Dial(ctx, socket, mode)
+	if err != nil {
+		return err
+	}
+	return c.Copy(ctx, stdin, stdout, stderr)
+}
+
+// Copy copies the output of the container to stdout and stderr until the
+// monitor goes away or ctx is done, and closes the connection. Unless stdin
+// is nil or another client writes to the stdin of the container, stdin is
+// copied to the container: its EOF closes the stdin of the container, any
+// other error of stdin ends the session and is returned, leaving the
+// container as it is. Once another client takes stdin over, stdin is read
+// but no longer copied.
+func (c *Conn) Copy(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) error {
+	conn := c.conn
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	var wmu sync.Mutex
+	stdinErr := make(chan error, 1)
+	if stdin != nil && c.HasStdin() {
+		go func() {
+			buf := make([]byte, 32<<10)
+			for {
+				n, err := stdin.Read(buf)
+				if !c.HasStdin() {
+					n = 0
+					if errors.Is(err, io.EOF) {
+						return
+					}
+				}
+				if n > 0 {
+					wmu.Lock()
+					werr := WriteFrame(conn, Stdin, buf[:n])
+					wmu.Unlock()
+					if werr != nil {
+						return
+					}
+				}
+				if errors.Is(err, io.EOF) {
+					wmu.Lock()
+					WriteFrame(conn, Stdin, nil)
+					wmu.Unlock()
+					return
+				}
+				if err != nil {
+					stdinErr <- err
+					conn.Close()
+					return
+				}
+			}
+		}()
+	}
+
+	for {
+		stream, data, err := ReadFrame(conn)
+		if err != nil {
+			select {
+			case err := <-stdinErr:
+				return err
+			default:
+			}
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if stream == Control {
+			if len(data) == 1 && data[0] == ctlStdinTaken {
+				c.stdin.Store(false)
+				logrus.Warn("stdin of the container was taken over by another attach client")
+			}
+			continue
+		}
+		w := stdout
+		if stream == Stderr {
+			w = stderr
+		}
+		if w == nil {
+			continue
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/containerd/console"
+	"github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// resizeInterval is how often the size of the console is checked for changes
+// made by the holder of the pty master.
+const resizeInterval = 200 * time.Millisecond
+
+// sendConsole allocates a pty, sends its master over the console socket the
+// way runc does and keeps the slave, which is bridged to the terminal FIFOs.
+func (m *Monitor) sendConsole(socket string) error {
+	master, slavePath, err := console.NewPty()
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return err
+	}
+	c, err := console.ConsoleFromFile(slave)
+	if err != nil {
+		slave.Close()
+		return err
+	}
+	// The terminal of the container does the line discipline already.
+	if err := c.SetRaw(); err != nil {
+		slave.Close()
+		return err
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		slave.Close()
+		return err
+	}
+	defer conn.Close()
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		slave.Close()
+		return fmt.Errorf("console socket %s is not a unix socket", socket)
+	}
+	f, err := uc.File()
+	if err != nil {
+		slave.Close()
+		return err
+	}
+	defer f.Close()
+	if err := utils.SendFd(f, master.Name(), master.Fd()); err != nil {
+		slave.Close()
+		return err
+	}
+	m.console = c
+	return nil
+}
+
+// copyConsoleInput forwards what the holder of the pty master types to the
+// stdin of the container.
+func (m *Monitor) copyConsoleInput() {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := m.console.Read(buf)
+		if n > 0 {
+			if err := m.stdin(buf[:n]); err != nil {
+				logrus.WithError(err).Debug("failed to write container stdin")
+			}
+		}
+		if err != nil {
+			// EIO once the master is closed.
+			return
+		}
+	}
+}
+
+// WatchResize calls resize whenever the holder of the pty master changes the
+// size of the console, until ctx is done. It returns at once when the
+// container has no console.
+func (m *Monitor) WatchResize(ctx context.Context, resize func(width, height uint32) error) {
+	if m.console == nil {
+		return
+	}
+	ticker := time.NewTicker(resizeInterval)
+	defer ticker.Stop()
+	var last console.WinSize
+	for {
+		size, err := m.console.Size()
+		if err == nil && size != last && size.Width > 0 && size.Height > 0 {
+			if err := resize(uint32(size.Width), uint32(size.Height)); err != nil {
+				logrus.WithError(err).Debug("failed to resize pty")
+			} else {
+				last = size
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,608 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/console"
+	"github.com/containerd/containerd/defaults"
+	"github.com/kata-contrib/runs/pkg/cio"
+	"github.com/kata-contrib/runs/pkg/logdriver"
+	"github.com/kata-contrib/runs/pkg/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	infoFilename   = "monitor.json"
+	socketFilename = "attach.sock"
+	logFilename    = "container.log"
+
+	// clientSetupTimeout bounds how long an attach client may take to ask
+	// for stdin.
+	clientSetupTimeout = 5 * time.Second
+
+	// outputBufferSize is the size of the chunks the output is read in.
+	outputBufferSize = 32 << 10
+)
+
+// Info describes the running monitor of a container. It is saved in the state
+// directory of the container while the monitor runs.
+type Info struct {
+	Pid      int    `json:"pid"`
+	Stdin    string `json:"stdin,omitempty"`
+	Stdout   string `json:"stdout,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+	Terminal bool   `json:"terminal,omitempty"`
+	// Socket accepts attach connections
+	Socket string `json:"socket"`
+	// LogPath is the file the output of the container is written to
+	LogPath string `json:"log_path,omitempty"`
+	// LogFormat is the format of the log
+	LogFormat cio.LogFormat `json:"log_format,omitempty"`
+	// LogDriver sends the output to journald or syslog instead of the log
+	LogDriver string `json:"log_driver,omitempty"`
+}
+
+// SocketPath returns the attach socket of the monitor of a container.
+func SocketPath(root, id string) string {
+	return filepath.Join(root, id, socketFilename)
+}
+
+// LogPath returns the default log file of the output of a container.
+func LogPath(root, id string) string {
+	return filepath.Join(root, id, logFilename)
+}
+
+// LoadInfo reads the description of the running monitor of a container.
+func LoadInfo(root, id string) (*Info, error) {
+	data, err := os.ReadFile(filepath.Join(root, id, infoFilename))
+	if err != nil {
+		return nil, err
+	}
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Config configures the monitor of a container.
+type Config struct {
+	// Root is the state root of the container
+	Root string
+	// ID of the container
+	ID string
+	// FIFODir is the directory the FIFOs are created in
+	FIFODir string
+	// Terminal is set when the container has a terminal, which merges
+	// stderr into stdout
+	Terminal bool
+	// LogPath is the file the output is written to, none when empty
+	LogPath string
+	// LogFormat is the format of the log, raw when empty
+	LogFormat cio.LogFormat
+	// LogMaxSize is the size the log is rotated at, no rotation when zero
+	LogMaxSize int64
+	// LogMaxFiles is the number of rotated logs kept
+	LogMaxFiles int
+	// LogCompress gzips the rotated logs
+	LogCompress bool
+	// LogRateLimit is the number of bytes per second written to the log,
+	// output past it is dropped and counted; no limit when zero, and at
+	// least MinLogRateLimit otherwise
+	LogRateLimit int64
+	// LogDriver sends the lines of output to journald or syslog instead of
+	// LogPath, unless it is empty or file
+	LogDriver string
+	// LogDriverAddress is the socket of the log driver, its default when
+	// empty
+	LogDriverAddress string
+	// LogName is the container name the log driver tags lines with, the ID
+	// when empty
+	LogName string
+	// ConsoleSocket receives the master of a pty bridged to the terminal
+	ConsoleSocket string
+	// NoStdin leaves the container without stdin
+	NoStdin bool
+	// StdinFile is a file copied to the stdin of the container, which is
+	// closed at its end
+	StdinFile string
+}
+
+// Monitor owns the IO FIFOs of a container for as long as it runs: it keeps
+// them open, writes the output to the log and copies it to attach clients,
+// and forwards their input to the container.
+type Monitor struct {
+	config Config
+	info   Info
+	fifos  *cio.FIFOSet
+	io     *cio.DirectIO
+	log    *util.RotatingFile
+	// driver queues the output for journald or syslog
+	driver *logdriver.Queue
+	// logStreams write the output of stdout and stderr to the log
+	logStreams map[byte]io.WriteCloser
+	limiter    *rateLimiter
+	// dropped counts the bytes of stdout and stderr left out of the log
+	dropped [3]uint64
+	l       net.Listener
+	// console is the slave of the pty sent over the console socket
+	console console.Console
+	// stdinFile is copied to the stdin of the container
+	stdinFile *os.File
+
+	// clients receive the output as frames
+	clients *cio.Broadcaster
+
+	stdinMu   sync.Mutex
+	stdinDone bool
+	// stdinWriteMu orders the writes to stdin, which block while the
+	// container does not read it; stdinMu is not held meanwhile so that
+	// stdin can still be taken over
+	stdinWriteMu sync.Mutex
+	// stdinOwner is the attach client writing to stdin
+	stdinOwner net.Conn
+	// closeIO closes the stdin of the task once stdin is done
+	closeIO func() error
+
+	copying sync.WaitGroup
+}
+
+// New creates the FIFOs and the attach socket of a container.
+func New(ctx context.Context, config Config) (_ *Monitor, retErr error) {
+	if config.FIFODir == "" {
+		config.FIFODir = defaults.DefaultFIFODir
+	}
+	if err := checkRateLimit(config.LogRateLimit); err != nil {
+		return nil, err
+	}
+	fifos, err := cio.NewFIFOSetInDir(config.FIFODir, config.ID, config.Terminal)
+	if err != nil {
+		return nil, err
+	}
+	if config.Terminal {
+		fifos.Stderr = ""
+	}
+	if config.NoStdin {
+		if config.StdinFile != "" {
+			fifos.Close()
+			return nil, errors.New("cannot use a stdin file if the container has no stdin")
+		}
+		fifos.Stdin = ""
+	}
+	dio, err := cio.NewDirectIO(ctx, fifos)
+	if err != nil {
+		return nil, err
+	}
+	m := &Monitor{
+		config:  config,
+		fifos:   fifos,
+		io:      dio,
+		clients: cio.NewBroadcaster(0),
+	}
+	defer func() {
+		if retErr != nil {
+			m.Close()
+		}
+	}()
+
+	switch {
+	case config.LogDriver != "" && config.LogDriver != logdriver.File:
+		d, err := logdriver.New(config.LogDriver, config.LogDriverAddress, logdriver.Info{
+			ID:   config.ID,
+			Name: config.LogName,
+		})
+		if err != nil {
+			return nil, err
+		}
+		m.driver = logdriver.NewQueue(d, 0)
+		m.limiter = newRateLimiter(config.LogRateLimit)
+		m.logStreams = map[byte]io.WriteCloser{
+			Stdout: m.driverStream(cio.StreamStdout),
+			Stderr: m.driverStream(cio.StreamStderr),
+		}
+	case config.LogPath != "":
+		if config.LogFormat == "" {
+			config.LogFormat = cio.LogFormatRaw
+		}
+		if m.log, err = util.NewRotatingFile(config.LogPath, config.LogMaxSize, config.LogMaxFiles, config.LogCompress); err != nil {
+			return nil, err
+		}
+		m.limiter = newRateLimiter(config.LogRateLimit)
+		lw := cio.NewLogWriter(m.log, config.LogFormat)
+		m.logStreams = map[byte]io.WriteCloser{
+			Stdout: lw.Stream(cio.StreamStdout),
+			Stderr: lw.Stream(cio.StreamStderr),
+		}
+	}
+	if config.StdinFile != "" {
+		if m.stdinFile, err = os.Open(config.StdinFile); err != nil {
+			return nil, err
+		}
+	}
+	if config.ConsoleSocket != "" {
+		if !config.Terminal {
+			return nil, errors.New("cannot use console socket if the container has no terminal")
+		}
+		if err := m.sendConsole(config.ConsoleSocket); err != nil {
+			return nil, fmt.Errorf("failed to send console: %w", err)
+		}
+	}
+	socket := SocketPath(config.Root, config.ID)
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if m.l, err = net.Listen("unix", socket); err != nil {
+		return nil, err
+	}
+
+	m.info = Info{
+		Pid:      os.Getpid(),
+		Stdin:    fifos.Stdin,
+		Stdout:   fifos.Stdout,
+		Stderr:   fifos.Stderr,
+		Terminal: config.Terminal,
+		Socket:   socket,
+		LogPath:  config.LogPath,
+	}
+	if m.driver != nil {
+		m.info.LogPath = ""
+		m.info.LogDriver = config.LogDriver
+	} else if config.LogPath != "" {
+		m.info.LogFormat = config.LogFormat
+	}
+	if err := m.writeInfo(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Info returns the description of the monitor, with the FIFOs to hand to the
+// shim.
+func (m *Monitor) Info() Info {
+	return m.info
+}
+
+func (m *Monitor) writeInfo() error {
+	dir := filepath.Join(m.config.Root, m.config.ID)
+	f, err := os.CreateTemp(dir, infoFilename)
+	if err != nil {
+		return err
+	}
+	if err := util.WriteJSON(f, m.info); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(dir, infoFilename))
+}
+
+// Dropped returns the number of bytes of stdout and stderr left out of the
+// log by the rate limit, or by a log driver that could not keep up.
+func (m *Monitor) Dropped() (stdout, stderr uint64) {
+	stdout, stderr = atomic.LoadUint64(&m.dropped[Stdout]), atomic.LoadUint64(&m.dropped[Stderr])
+	if m.driver != nil {
+		queueStdout, queueStderr := m.driver.Dropped()
+		stdout += queueStdout
+		stderr += queueStderr
+	}
+	return stdout, stderr
+}
+
+// IO returns the IO of the FIFOs of the container, which counts what went
+// through them and keeps the first error copying them.
+func (m *Monitor) IO() cio.IO {
+	return m.io
+}
+
+// Serve starts copying the output of the container and accepting attach
+// clients.
+func (m *Monitor) Serve() {
+	if m.io.Stdout != nil {
+		m.copying.Add(1)
+		go m.copyOutput(Stdout, m.io.Stdout)
+	}
+	if m.io.Stderr != nil {
+		m.copying.Add(1)
+		go m.copyOutput(Stderr, m.io.Stderr)
+	}
+	if m.console != nil {
+		go m.copyConsoleInput()
+	}
+	if m.stdinFile != nil {
+		go m.copyStdinFile()
+	}
+	go m.accept()
+}
+
+// Drain waits until the output of the container is copied, at most timeout.
+func (m *Monitor) Drain(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		m.copying.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+// Close disconnects the attach clients and releases the FIFOs.
+func (m *Monitor) Close() error {
+	if m.l != nil {
+		m.l.Close()
+		os.Remove(m.info.Socket)
+		os.Remove(filepath.Join(m.config.Root, m.config.ID, infoFilename))
+	}
+	m.clients.Close()
+
+	m.io.Cancel()
+	err := m.io.Close()
+	if m.console != nil {
+		m.console.Close()
+	}
+	if m.stdinFile != nil {
+		m.stdinFile.Close()
+	}
+	for _, w := range m.logStreams {
+		w.Close()
+	}
+	if m.log != nil {
+		m.log.Close()
+	}
+	if m.driver != nil {
+		m.driver.Close()
+	}
+	return err
+}
+
+// driverStream returns the writer sending the lines of a stream to the log
+// driver. The last line is complete even without its newline.
+func (m *Monitor) driverStream(stream string) io.WriteCloser {
+	return cio.NewLineWriter(func(line []byte, partial bool) error {
+		return m.driver.Log(stream, line, partial)
+	}, false)
+}
+
+func (m *Monitor) copyOutput(stream byte, r io.Reader) {
+	defer m.copying.Done()
+	// The output is read right behind the header of its frame.
+	buf := make([]byte, frameHeaderSize+outputBufferSize)
+	for {
+		n, err := r.Read(buf[frameHeaderSize:])
+		if n > 0 {
+			m.output(stream, buf[:frameHeaderSize+n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// output writes a chunk of output to the log and all attach clients. The
+// chunk follows room for the header of its frame.
+func (m *Monitor) output(stream byte, frame []byte) {
+	data := frame[frameHeaderSize:]
+	if w := m.logStreams[stream]; w != nil {
+		if !m.limiter.allow(len(data)) {
+			atomic.AddUint64(&m.dropped[stream], uint64(len(data)))
+			// The text around the dropped chunk must not be joined
+			// into a line the container never wrote.
+			if lw, ok := w.(*cio.LineWriter); ok {
+				if err := lw.Break(); err != nil {
+					logrus.WithError(err).Warn("failed to write container log")
+				}
+			}
+		} else if _, err := w.Write(data); err != nil {
+			logrus.WithError(err).Warn("failed to write container log")
+		}
+	}
+	if m.console != nil {
+		if _, err := m.console.Write(data); err != nil {
+			logrus.WithError(err).Debug("failed to write console")
+		}
+	}
+	putFrameHeader(frame, stream, len(data))
+	m.clients.Write(frame)
+}
+
+func (m *Monitor) accept() {
+	for {
+		conn, err := m.l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logrus.WithError(err).Warn("failed to accept attach client")
+			}
+			return
+		}
+		go m.serve(conn)
+	}
+}
+
+// serve sets up an attach client, then forwards its stdin frames to the
+// container while it owns stdin.
+func (m *Monitor) serve(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(clientSetupTimeout))
+	stream, data, err := ReadFrame(conn)
+	if err != nil || stream != Control || len(data) != 1 {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	ack := ctlAttached
+	if mode := StdinMode(data[0]); mode != NoStdin && m.claimStdin(conn, mode == TakeStdin) {
+		ack = ctlStdinGranted
+	}
+	// The acknowledgment tells the client it gets all output from now on.
+	frame := make([]byte, frameHeaderSize+1)
+	putFrameHeader(frame, Control, 1)
+	frame[frameHeaderSize] = ack
+	done, err := m.clients.Add(conn, frame)
+	if err != nil {
+		m.releaseStdin(conn)
+		conn.Close()
+		return
+	}
+	defer func() {
+		m.releaseStdin(conn)
+		m.clients.Remove(conn)
+	}()
+
+	frames := make(chan struct{})
+	go func() {
+		defer close(frames)
+		for {
+			stream, data, err := ReadFrame(conn)
+			if err != nil {
+				return
+			}
+			if stream != Stdin {
+				continue
+			}
+			if err := m.stdinFrom(conn, data); err != nil {
+				logrus.WithError(err).Debug("failed to write container stdin")
+			}
+		}
+	}()
+	select {
+	case <-frames:
+	case <-done:
+	}
+}
+
+// claimStdin makes conn the writer of stdin, unless another client is and
+// take is not set. The client it is taken from is told so.
+func (m *Monitor) claimStdin(conn net.Conn, take bool) bool {
+	m.stdinMu.Lock()
+	defer m.stdinMu.Unlock()
+	if m.io.Stdin == nil || m.stdinDone {
+		return false
+	}
+	if m.stdinOwner != nil {
+		if !take {
+			return false
+		}
+		frame := make([]byte, frameHeaderSize+1)
+		putFrameHeader(frame, Control, 1)
+		frame[frameHeaderSize] = ctlStdinTaken
+		m.clients.Send(m.stdinOwner, frame)
+	}
+	m.stdinOwner = conn
+	return true
+}
+
+// releaseStdin lets another client write to stdin once conn is gone.
+func (m *Monitor) releaseStdin(conn net.Conn) {
+	m.stdinMu.Lock()
+	defer m.stdinMu.Unlock()
+	if m.stdinOwner == conn {
+		m.stdinOwner = nil
+	}
+}
+
+// stdinFrom writes the stdin frame of an attach client to the container,
+// unless the client does not own stdin. An empty frame ends stdin.
+func (m *Monitor) stdinFrom(conn net.Conn, data []byte) error {
+	m.stdinMu.Lock()
+	owner := m.stdinOwner
+	m.stdinMu.Unlock()
+	if owner != conn {
+		return nil
+	}
+	if len(data) == 0 {
+		m.closeStdin()
+		return nil
+	}
+	return m.stdin(data)
+}
+
+// SetCloseIO sets how the stdin of the task is closed once the stdin of the
+// container ends. Shims such as kata's do not pass the end of the FIFO on to
+// the process, which keeps waiting for input until the task is told. If stdin
+// already ended, closeIO is called right away.
+func (m *Monitor) SetCloseIO(closeIO func() error) {
+	m.stdinMu.Lock()
+	m.closeIO = closeIO
+	done := m.stdinDone
+	m.stdinMu.Unlock()
+	if done {
+		m.callCloseIO(closeIO)
+	}
+}
+
+// closeStdin closes the stdin FIFO of the container, then the stdin of the
+// task.
+func (m *Monitor) closeStdin() {
+	m.stdinMu.Lock()
+	if m.stdinDone || m.io.Stdin == nil {
+		m.stdinMu.Unlock()
+		return
+	}
+	m.stdinDone = true
+	err := m.io.Stdin.Close()
+	closeIO := m.closeIO
+	m.stdinMu.Unlock()
+	if err != nil {
+		logrus.WithError(err).Debug("failed to close container stdin")
+	}
+	if closeIO != nil {
+		m.callCloseIO(closeIO)
+	}
+}
+
+func (m *Monitor) callCloseIO(closeIO func() error) {
+	if err := closeIO(); err != nil {
+		logrus.WithError(err).Warn("failed to close stdin of task")
+	}
+}
+
+// copyStdinFile copies the stdin file to the container, then closes its
+// stdin and the stdin of the task.
+func (m *Monitor) copyStdinFile() {
+	buf := make([]byte, outputBufferSize)
+	for {
+		n, err := m.stdinFile.Read(buf)
+		if n > 0 {
+			if err := m.stdin(buf[:n]); err != nil {
+				logrus.WithError(err).Warn("failed to write stdin file to container")
+				return
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				logrus.WithError(err).Warn("failed to read stdin file")
+			}
+			break
+		}
+	}
+	m.closeStdin()
+}
+
+// stdin writes to the stdin of the container.
+func (m *Monitor) stdin(data []byte) error {
+	m.stdinMu.Lock()
+	done := m.stdinDone || m.io.Stdin == nil
+	m.stdinMu.Unlock()
+	if done {
+		return nil
+	}
+	m.stdinWriteMu.Lock()
+	defer m.stdinWriteMu.Unlock()
+	_, err := m.io.Stdin.Write(data)
+	return err
+}
@@ -0,0 +1,190 @@
+package monitor
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestMonitor starts serving a monitor for the container c1 under a
+// temporary root.
+func newTestMonitor(t *testing.T, config Config) *Monitor {
+	t.Helper()
+	config.Root = t.TempDir()
+	config.ID = "c1"
+	config.FIFODir = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(config.Root, config.ID), 0711); err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	m.Serve()
+	return m
+}
+
+// openStdin opens the stdin FIFO of the container the way the shim does.
+func openStdin(t *testing.T, m *Monitor) *os.File {
+	t.Helper()
+	f, err := os.OpenFile(m.Info().Stdin, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestAttachStdinEndClosesTaskStdin(t *testing.T) {
+	m := newTestMonitor(t, Config{})
+	stdin := openStdin(t, m)
+	closed := make(chan struct{}, 2)
+	m.SetCloseIO(func() error {
+		closed <- struct{}{}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn, err := Dial(ctx, m.Info().Socket, WantStdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conn.HasStdin() {
+		t.Fatal("stdin not granted")
+	}
+	go conn.Copy(ctx, strings.NewReader("hello\n"), io.Discard, io.Discard)
+
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello\n" {
+		t.Fatalf("stdin %q, want %q", data, "hello\n")
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stdin of the task not closed")
+	}
+	select {
+	case <-closed:
+		t.Fatal("stdin of the task closed twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestCloseIOSetAfterStdinEnded(t *testing.T) {
+	m := newTestMonitor(t, Config{})
+	openStdin(t, m)
+	m.closeStdin()
+
+	closed := make(chan struct{}, 1)
+	m.SetCloseIO(func() error {
+		closed <- struct{}{}
+		return nil
+	})
+	select {
+	case <-closed:
+	default:
+		t.Fatal("stdin of the task not closed once the task is known")
+	}
+}
+
+func TestStdinFileEndClosesTaskStdin(t *testing.T) {
+	content := strings.Repeat("some line of the stdin file\n", 4096)
+	file := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	m := newTestMonitor(t, Config{StdinFile: file})
+
+	// Like kata's shim, copy the FIFO to the process without passing its
+	// end on, and close the stdin of the process on CloseIO once the copy
+	// is done.
+	stdin := openStdin(t, m)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(w, stdin)
+		close(copied)
+	}()
+	cat := exec.Command("cat")
+	cat.Stdin = r
+	var out strings.Builder
+	cat.Stdout = &out
+	if err := cat.Start(); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	m.SetCloseIO(func() error {
+		<-copied
+		return w.Close()
+	})
+	exited := make(chan error, 1)
+	go func() { exited <- cat.Wait() }()
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		cat.Process.Kill()
+		t.Fatal("cat did not exit at the end of the stdin file")
+	}
+	if out.String() != content {
+		t.Fatalf("cat read %d bytes, want %d", out.Len(), len(content))
+	}
+}
+
+func TestTakeStdinFromBlockedOwner(t *testing.T) {
+	m := newTestMonitor(t, Config{})
+	// The container does not read its stdin until the end of the test.
+	stdin := openStdin(t, m)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	owner, err := Dial(ctx, m.Info().Socket, WantStdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// More than the FIFO holds, the monitor blocks writing it.
+	input := strings.Repeat("x", 1<<20)
+	go owner.Copy(ctx, strings.NewReader(input), io.Discard, io.Discard)
+	for i := 0; m.IO().Stats().Stdin.Bytes < 64<<10; i++ {
+		if i == 500 {
+			t.Fatal("stdin not written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	dctx, dcancel := context.WithTimeout(ctx, 2*time.Second)
+	defer dcancel()
+	taker, err := Dial(dctx, m.Info().Socket, TakeStdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taker.conn.Close()
+	if !taker.HasStdin() {
+		t.Fatal("stdin not taken over")
+	}
+	for i := 0; owner.HasStdin(); i++ {
+		if i == 500 {
+			t.Fatal("previous owner not told stdin was taken")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	go io.Copy(io.Discard, stdin)
+}
@@ -0,0 +1,65 @@
+package monitor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MinLogRateLimit is the lowest rate limit of the log, in bytes per second.
+// The output is read in chunks of up to this size, and a chunk has to fit in
+// the second of output the limiter lets through at once.
+const MinLogRateLimit = outputBufferSize
+
+// checkRateLimit checks a rate limit of the log, zero meaning none.
+func checkRateLimit(rate int64) error {
+	if rate > 0 && rate < MinLogRateLimit {
+		return fmt.Errorf("log rate limit %d is below the minimum of %d bytes per second", rate, MinLogRateLimit)
+	}
+	return nil
+}
+
+// rateLimiter is a token bucket of bytes, refilled at a steady rate and
+// holding at most a second of it.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter of rate bytes per second, or none when rate
+// is zero or less.
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:   float64(rate),
+		burst:  float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// allow takes n bytes from the bucket, unless it holds fewer. A nil limiter
+// allows everything.
+func (l *rateLimiter) allow(n int) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if float64(n) > l.tokens {
+		return false
+	}
+	l.tokens -= float64(n)
+	return true
+}
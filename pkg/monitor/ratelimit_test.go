@@ -0,0 +1,74 @@
+package monitor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if l := newRateLimiter(0); !l.allow(1 << 30) {
+		t.Fatal("no limit dropped output")
+	}
+
+	l := newRateLimiter(MinLogRateLimit)
+	if !l.allow(MinLogRateLimit) {
+		t.Fatal("a second of output dropped")
+	}
+	if l.allow(1024) {
+		t.Fatal("output past a second allowed")
+	}
+	// Half a second refills half of the bucket.
+	l.last = l.last.Add(-500 * time.Millisecond)
+	if !l.allow(MinLogRateLimit / 4) {
+		t.Fatal("refilled output dropped")
+	}
+	if l.allow(MinLogRateLimit / 2) {
+		t.Fatal("more than the refill allowed")
+	}
+	// The bucket holds at most a second of output however long it waited.
+	l.last = l.last.Add(-time.Hour)
+	if l.allow(MinLogRateLimit + 1) {
+		t.Fatal("more than a second of output allowed at once")
+	}
+}
+
+func TestRateLimitMinimum(t *testing.T) {
+	if err := checkRateLimit(MinLogRateLimit - 1); err == nil {
+		t.Fatal("rate below the minimum accepted")
+	}
+	for _, rate := range []int64{0, MinLogRateLimit, 1 << 20} {
+		if err := checkRateLimit(rate); err != nil {
+			t.Fatalf("rate %d: %v", rate, err)
+		}
+	}
+}
+
+func TestRateLimitDropped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "container.log")
+	m := newTestMonitor(t, Config{LogPath: path, LogRateLimit: MinLogRateLimit})
+
+	out := func(stream byte, s string) {
+		frame := make([]byte, frameHeaderSize+len(s))
+		copy(frame[frameHeaderSize:], s)
+		m.output(stream, frame)
+	}
+	first := strings.Repeat("a", MinLogRateLimit-10) + "\n"
+	out(Stdout, first)
+	out(Stdout, "dropped line\n")
+	out(Stderr, "dropped too\n")
+
+	stdout, stderr := m.Dropped()
+	if stdout != uint64(len("dropped line\n")) || stderr != uint64(len("dropped too\n")) {
+		t.Fatalf("dropped %d and %d bytes", stdout, stderr)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != first {
+		t.Fatalf("log holds %d bytes, want %d", len(data), len(first))
+	}
+}
@@ -43,6 +43,8 @@ type shimBinaryConfig struct {
 	address      string
 	ttrpcAddress string
 	schedCore    bool
+	logger       ShimLogger
+	logPath      string
 }
 
 func shimBinary(bundle *Bundle, config shimBinaryConfig) *binary {
@@ -51,6 +53,8 @@ func shimBinary(bundle *Bundle, config shimBinaryConfig) *binary {
 		runtime:                config.runtime,
 		containerdAddress:      config.address,
 		containerdTTRPCAddress: config.ttrpcAddress,
+		logger:                 config.logger,
+		logPath:                config.logPath,
 	}
 }
 
@@ -59,6 +63,8 @@ type binary struct {
 	containerdAddress      string
 	containerdTTRPCAddress string
 	bundle                 *Bundle
+	logger                 ShimLogger
+	logPath                string
 }
 
 func (b *binary) Start(ctx context.Context, opts *types.Any, onClose func()) (_ *shim, err error) {
@@ -81,7 +87,7 @@ func (b *binary) Start(ctx context.Context, opts *types.Any, onClose func()) (_
 			Args:         args,
 		})
 	//	out, err := cmd.CombinedOutput()
-	//	log.G(ctx).WithError(err).Error("log 000000000000 %s", string(out))
+	//	log.G(ctx).WithError(err).Errorf("log 000000000000 %s", string(out))
 	//	log.G(ctx).WithError(err).Error("log 000000000000")
 
 	if err != nil {
@@ -96,61 +102,86 @@ func (b *binary) Start(ctx context.Context, opts *types.Any, onClose func()) (_
 			cancelShimLog()
 		}
 	}()
-	f, err := openShimLog(shimCtx, b.bundle, client.AnonDialer)
-
-	if err != nil {
-		return nil, fmt.Errorf("open shim log pipe: %w", err)
-	}
-	defer func() {
-		if err != nil {
-			f.Close()
+	var f io.ReadCloser
+	if b.logger != nil {
+		// The logger outlives runs and persists the shim's logs.
+		if err := CreateShimLogPipe(b.bundle); err != nil {
+			return nil, fmt.Errorf("create shim log pipe: %w", err)
 		}
-	}()
-	// open the log pipe and block until the writer is ready
-	// this helps with synchronization of the shim
-	// copy the shim's logs to containerd's output
-
-	log.G(ctx).WithError(err).Error("log cccccccccccccccc")
-	go func() {
-		defer f.Close()
-		_, err := io.Copy(os.Stderr, f)
-		// To prevent flood of error messages, the expected error
-		// should be reset, like os.ErrClosed or os.ErrNotExist, which
-		// depends on platform.
-		err = checkCopyShimLogError(ctx, err)
-
-		log.G(ctx).WithError(err).Error("log bbbbbbbbbbbbbbbbbbbb")
-		if err != nil {
-			log.G(ctx).WithError(err).Error("copy shim log")
+		var stop func()
+		if stop, err = b.logger(shimCtx, b.bundle, b.logPath); err != nil {
+			return nil, fmt.Errorf("start shim logger: %w", err)
 		}
-	}()
+		defer func() {
+			if err != nil {
+				stop()
+			}
+		}()
+	} else {
+		if f, err = b.copyShimLog(ctx, shimCtx); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				f.Close()
+			}
+		}()
+	}
 	out, err := cmd.CombinedOutput()
 
-	log.G(ctx).WithError(err).Error("log 000000000000 %s", string(out))
+	log.G(ctx).WithError(err).Errorf("log 000000000000 %s", string(out))
 	log.G(ctx).WithError(err).Error("log 000000000000")
 	if err != nil {
 		log.G(ctx).WithError(err).Error("log aaaaaaaaaaaaaaa")
 		return nil, fmt.Errorf("%s: %w", out, err)
 	}
 	address := strings.TrimSpace(string(out))
-	conn, err := client.Connect(address, client.AnonDialer)
+	conn, err := dialShim(ctx, address)
 	if err != nil {
 		return nil, err
 	}
+	s := &shim{
+		bundle:  b.bundle,
+		address: address,
+		onClose: onClose,
+		pid:     peerPid(conn),
+	}
 	onCloseWithShimLog := func() {
-		onClose()
+		s.disconnected()
 		cancelShimLog()
-		f.Close()
+		if f != nil {
+			f.Close()
+		}
 	}
 	// Save runtime binary path for restore.
 	if err := os.WriteFile(filepath.Join(b.bundle.Path, "shim-binary-path"), []byte(b.runtime), 0600); err != nil {
 		return nil, err
 	}
-	client := ttrpc.NewClient(conn, ttrpc.WithOnClose(onCloseWithShimLog))
-	return &shim{
-		bundle: b.bundle,
-		client: client,
-	}, nil
+	s.client = ttrpc.NewClient(conn, ttrpc.WithOnClose(onCloseWithShimLog))
+	return s, nil
+}
+
+// copyShimLog copies the shim's logs to the output of this process.
+func (b *binary) copyShimLog(ctx, shimCtx context.Context) (io.ReadCloser, error) {
+	f, err := openShimLog(shimCtx, b.bundle, client.AnonDialer)
+	if err != nil {
+		return nil, fmt.Errorf("open shim log pipe: %w", err)
+	}
+	// open the log pipe and block until the writer is ready
+	// this helps with synchronization of the shim
+	// copy the shim's logs to containerd's output
+	go func() {
+		defer f.Close()
+		_, err := io.Copy(os.Stderr, f)
+		// To prevent flood of error messages, the expected error
+		// should be reset, like os.ErrClosed or os.ErrNotExist, which
+		// depends on platform.
+		err = checkCopyShimLogError(ctx, err)
+		if err != nil {
+			log.G(ctx).WithError(err).Error("copy shim log")
+		}
+	}()
+	return f, nil
 }
 
 func (b *binary) Delete(ctx context.Context) (*runtime.Exit, error) {
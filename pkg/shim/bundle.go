@@ -45,7 +45,7 @@ func NewBundle(ctx context.Context, state, id string, spec typeurl.Any) (b *Bund
 		return nil, err
 	}
 
-	work := filepath.Join("/run/runs/", id)
+	work := filepath.Join(state, id)
 	b = &Bundle{
 		ID:        id,
 		Path:      path,
@@ -123,3 +123,25 @@ type Bundle struct {
 func (b *Bundle) Delete() error {
 	return nil
 }
+
+// bundleFiles are the files runs and the shims leave in a bundle directory.
+var bundleFiles = []string{
+	"work",
+	"address",
+	"sandbox",
+	"log",
+	"shim-binary-path",
+	shimBundleFilename,
+	shimLoggerPidFilename,
+}
+
+// RemoveBundleFiles removes what runs and the shims added to a bundle
+// directory, leaving the spec and the root filesystem in place.
+func RemoveBundleFiles(path string) error {
+	for _, name := range bundleFiles {
+		if err := os.Remove(filepath.Join(path, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
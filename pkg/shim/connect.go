@@ -0,0 +1,147 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package shim
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/containerd/log"
+	client "github.com/containerd/containerd/runtime/v2/shim"
+	"github.com/containerd/ttrpc"
+)
+
+const (
+	// dialTimeout bounds a single attempt to connect to a shim socket
+	dialTimeout = 2 * time.Second
+	// backoffInitial is the delay before the first retry, it doubles with
+	// every attempt up to backoffMax
+	backoffInitial = 50 * time.Millisecond
+	backoffMax     = time.Second
+	// backoffAttempts bounds the number of attempts of a call
+	backoffAttempts = 8
+)
+
+// permanent stops backoff from retrying an otherwise transient error.
+type permanent struct {
+	error
+}
+
+func (p permanent) Unwrap() error {
+	return p.error
+}
+
+// isTransient reports whether err is a failure to reach the shim that may go
+// away by itself, such as a restarting shim or a socket that is briefly
+// unreachable, as opposed to an error returned by the shim.
+func isTransient(err error) bool {
+	var p permanent
+	if err == nil || errors.As(err, &p) {
+		return false
+	}
+	return errors.Is(err, ttrpc.ErrClosed) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ENOENT) ||
+		errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF)
+}
+
+// backoff calls fn until it succeeds, fails with an error that is not
+// transient, runs out of attempts or ctx is done.
+func backoff(ctx context.Context, fn func() error) error {
+	delay := backoffInitial
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if !isTransient(err) || attempt == backoffAttempts {
+			return err
+		}
+		log.G(ctx).WithError(err).WithField("attempt", attempt).Debug("shim unreachable, retrying")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > backoffMax {
+			delay = backoffMax
+		}
+	}
+}
+
+// dialShim connects to the shim socket at address, retrying while it is
+// unreachable.
+func dialShim(ctx context.Context, address string) (net.Conn, error) {
+	var conn net.Conn
+	err := backoff(ctx, func() (err error) {
+		conn, err = client.Connect(address, func(address string, _ time.Duration) (net.Conn, error) {
+			return client.AnonReconnectDialer(address, dialTimeout)
+		})
+		return err
+	})
+	return conn, err
+}
+
+// reconnect replaces a connection to the shim that was closed under us.
+// stale is the client the failed call used, if it was already replaced by
+// another call there is nothing to do.
+func (s *shimTask) reconnect(stale *ttrpc.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return permanent{ttrpc.ErrClosed}
+	}
+	if s.client != stale {
+		return nil
+	}
+	conn, err := client.AnonReconnectDialer(s.address, dialTimeout)
+	if err != nil {
+		return err
+	}
+	s.client = ttrpc.NewClient(conn, ttrpc.WithOnClose(s.disconnected))
+	s.task = task.NewTaskClient(s.client)
+	s.pid = peerPid(conn)
+	return nil
+}
+
+// retry runs an idempotent call to the shim, reconnecting and calling it
+// again while the shim is unreachable. A shim still unreachable once the
+// attempts run out is cleaned up after if its process is gone.
+func (s *shimTask) retry(ctx context.Context, call func(task.TaskService) error) error {
+	err := backoff(ctx, func() error {
+		s.mu.Lock()
+		stale, t := s.client, s.task
+		s.mu.Unlock()
+
+		err := call(t)
+		if errors.Is(err, ttrpc.ErrClosed) {
+			if rerr := s.reconnect(stale); rerr != nil {
+				return rerr
+			}
+		}
+		return err
+	})
+	if isTransient(err) {
+		s.checkDead()
+	}
+	return err
+}
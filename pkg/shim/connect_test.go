@@ -0,0 +1,64 @@
+package shim
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+)
+
+func TestShimDeadOnlyOnceGone(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "default")
+	path := t.TempDir()
+	_, server := serveFakeShim(t, path, &fakeShim{})
+
+	var closed, dead int32
+	s, err := LoadShim(ctx, &Bundle{ID: "c1", Path: path, Namespace: "default"}, func() {
+		atomic.AddInt32(&closed, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.onDead = func() { atomic.AddInt32(&dead, 1) }
+	if s.pid != os.Getpid() {
+		t.Fatalf("shim pid %d, want %d", s.pid, os.Getpid())
+	}
+
+	server.Close()
+	os.Remove(filepath.Join(path, "s"))
+	call := func() {
+		cctx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+		defer cancel()
+		if _, err := s.PID(cctx); err == nil {
+			t.Fatal("call to a stopped shim succeeded")
+		}
+	}
+
+	// The shim process, this one, still runs: it may come back.
+	call()
+	if atomic.LoadInt32(&closed) == 0 {
+		t.Error("lost connection not reported")
+	}
+	if n := atomic.LoadInt32(&dead); n != 0 {
+		t.Fatalf("cleaned up after a running shim %d times", n)
+	}
+
+	gone := exec.Command("true")
+	if err := gone.Run(); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	s.pid = gone.Process.Pid
+	s.mu.Unlock()
+	call()
+	call()
+	if n := atomic.LoadInt32(&dead); n != 1 {
+		t.Fatalf("cleaned up after a dead shim %d times, want 1", n)
+	}
+}
@@ -0,0 +1,274 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package shim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	eventstypes "github.com/containerd/containerd/api/events"
+	eventsapi "github.com/containerd/containerd/api/services/ttrpc/events/v1"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/runtime"
+	"github.com/containerd/ttrpc"
+	"github.com/containerd/typeurl"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"golang.org/x/sys/unix"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const (
+	eventsSocketFilename = "events.sock"
+	eventsLockFilename   = "events.lock"
+	journalFilename      = "events.json"
+)
+
+// EventsAddress returns the address of the events server of a state root,
+// which shims publish their events to.
+func EventsAddress(root string) string {
+	return filepath.Join(root, eventsSocketFilename)
+}
+
+// LockEventsServer takes an exclusive lock on starting the events server of
+// a state root, so that only one server is started for it.
+func LockEventsServer(root string) (unlock func(), err error) {
+	if err := os.MkdirAll(root, 0711); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(root, eventsLockFilename), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// EventsServerListening reports whether an events server accepts
+// connections on address.
+func EventsServerListening(address string) bool {
+	conn, err := net.DialTimeout("unix", address, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// JournalPath returns the path of the event journal of a container, one JSON
+// encoded JournalEntry per line.
+func JournalPath(root, id string) string {
+	return filepath.Join(root, id, journalFilename)
+}
+
+// JournalEntry is an event recorded in the journal of a container.
+type JournalEntry struct {
+	// ID is the container the event is about
+	ID        string          `json:"id"`
+	Timestamp time.Time       `json:"timestamp"`
+	Namespace string          `json:"namespace"`
+	Topic     string          `json:"topic"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// EventsServer implements the containerd events TTRPC service, so that shims
+// can publish their events without containerd. The task events are recorded
+// in the state and the journal of their container.
+type EventsServer struct {
+	root    string
+	handler func(*JournalEntry)
+}
+
+// NewEventsServer returns an events server for the containers of a state root.
+// Every recorded event is also passed to handler, if set.
+func NewEventsServer(root string, handler func(*JournalEntry)) *EventsServer {
+	return &EventsServer{root: root, handler: handler}
+}
+
+// Serve listens on the events address of the state root until ctx is done.
+func (s *EventsServer) Serve(ctx context.Context) error {
+	if err := os.MkdirAll(s.root, 0711); err != nil {
+		return err
+	}
+	address := EventsAddress(s.root)
+	if EventsServerListening(address) {
+		return fmt.Errorf("an events server is already listening on %s", address)
+	}
+	// A socket left behind by a server that died is in the way.
+	if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", address)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(address)
+
+	server, err := ttrpc.NewServer()
+	if err != nil {
+		l.Close()
+		return err
+	}
+	eventsapi.RegisterEventsService(server, s)
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.Serve(ctx, l); err != nil && !errors.Is(err, ttrpc.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Forward implements the events service. Events the server does not record
+// are accepted and dropped.
+func (s *EventsServer) Forward(ctx context.Context, r *eventsapi.ForwardRequest) (*emptypb.Empty, error) {
+	entry, err := RecordEvent(s.root, r.Envelope)
+	if err != nil {
+		log.G(ctx).WithError(err).WithField("topic", r.Envelope.GetTopic()).Warn("failed to record event")
+	}
+	if entry != nil && s.handler != nil {
+		s.handler(entry)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// RecordEvent records a task event in the state and the journal of its
+// container under root, and returns the journal entry. Other events, and
+// events of containers that are not under root, are ignored and yield no
+// entry.
+func RecordEvent(root string, env *eventsapi.Envelope) (*JournalEntry, error) {
+	if env == nil || env.Event == nil {
+		return nil, nil
+	}
+	v, err := typeurl.UnmarshalAny(env.Event)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		id     string
+		update func(*State)
+	)
+	switch e := v.(type) {
+	case *eventstypes.TaskStart:
+		id = e.ContainerID
+		update = func(state *State) {
+			state.Status = runtime.RunningStatus
+			state.InitProcessPid = int(e.Pid)
+		}
+	case *eventstypes.TaskExit:
+		id = e.ContainerID
+		// Exits of exec'd processes leave the container running.
+		if e.ID != "" && e.ID != e.ContainerID {
+			break
+		}
+		update = func(state *State) {
+			state.Status = runtime.StoppedStatus
+			state.ExitStatus = e.ExitStatus
+			state.ExitedAt = env.Timestamp.AsTime()
+			if e.ExitedAt != nil {
+				state.ExitedAt = e.ExitedAt.AsTime()
+			}
+			state.OOMKilled = oomKilled(state)
+		}
+	case *eventstypes.TaskOOM:
+		id = e.ContainerID
+		update = func(state *State) {
+			state.OOMEvents++
+			state.OOMKilled = oomKilled(state)
+		}
+	default:
+		return nil, nil
+	}
+	if id == "" {
+		return nil, nil
+	}
+	unlock, err := lockContainer(root, id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Not a container of this root, or already deleted.
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer unlock()
+
+	if update != nil {
+		state, err := LoadState(root, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load state of %s: %w", id, err)
+		}
+		update(state)
+		if err := writeState(root, id, state); err != nil {
+			return nil, fmt.Errorf("failed to update state of %s: %w", id, err)
+		}
+	}
+	data, err := protojson.Marshal(v.(proto.Message))
+	if err != nil {
+		return nil, err
+	}
+	entry := &JournalEntry{
+		ID:        id,
+		Timestamp: env.Timestamp.AsTime(),
+		Namespace: env.Namespace,
+		Topic:     env.Topic,
+		Event:     data,
+	}
+	if err := journal(root, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// oomKilledStatus is the exit status of a process killed by SIGKILL, which
+// is how the OOM killer ends it.
+const oomKilledStatus = 128 + 9
+
+// oomKilled reports whether the init process of a stopped container was
+// killed for running out of memory: the container saw OOM events, which are
+// not necessarily fatal, and its init process died of SIGKILL.
+func oomKilled(state *State) bool {
+	return state.Status == runtime.StoppedStatus && state.OOMEvents > 0 && state.ExitStatus == oomKilledStatus
+}
+
+// journal appends an entry to the journal of its container.
+func journal(root string, entry *JournalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(JournalPath(root, entry.ID), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
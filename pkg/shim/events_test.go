@@ -0,0 +1,83 @@
+package shim
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEventsServerKeepsLiveSocket(t *testing.T) {
+	root := t.TempDir()
+	address := EventsAddress(root)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	served := make(chan error, 1)
+	go func() { served <- NewEventsServer(root, nil).Serve(ctx) }()
+	for i := 0; !EventsServerListening(address); i++ {
+		if i == 100 {
+			t.Fatal("events server did not start listening")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := NewEventsServer(root, nil).Serve(ctx); err == nil {
+		t.Fatal("second events server started")
+	}
+	if !EventsServerListening(address) {
+		t.Fatal("second events server took the socket of the first")
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(address); !os.IsNotExist(err) {
+		t.Fatalf("socket left behind: %v", err)
+	}
+
+	// A socket left behind by a dead server is replaced.
+	if err := os.WriteFile(address, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() { served <- NewEventsServer(root, nil).Serve(ctx) }()
+	for i := 0; !EventsServerListening(address); i++ {
+		if i == 100 {
+			t.Fatal("events server did not replace the stale socket")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockEventsServer(t *testing.T) {
+	root := t.TempDir()
+	unlock, err := LockEventsServer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := LockEventsServer(root)
+		if err == nil {
+			unlock()
+		}
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("events server lock taken twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("events server lock not released")
+	}
+}
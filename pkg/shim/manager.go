@@ -19,6 +19,7 @@ package shim
 import (
 	"context"
 	sctx "context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -36,14 +37,20 @@ import (
 	"github.com/containerd/containerd/runtime"
 	shimbinary "github.com/containerd/containerd/runtime/v2/shim"
 	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/kata-contrib/runs/pkg/cio"
 	"github.com/kata-contrib/runs/pkg/util"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
 )
 
 type ManagerConfig struct {
 	State        string
 	Address      string
 	TTRPCAddress string
+	// ShimLogger, when set, takes over the logs of started shims
+	ShimLogger ShimLogger
+	// Pools are the pools of idle shims to take shims from, keyed by runtime
+	Pools map[string]PoolConfig
 }
 
 type State struct {
@@ -55,6 +62,39 @@ type State struct {
 	Bundle string `json:"bundle"`
 	// Created is the unix timestamp for the creation time of the container in UTC
 	Created time.Time `json:"created"`
+	// Runtime is the name or path of the shim runtime
+	Runtime string `json:"runtime,omitempty"`
+	// RuntimeOptions are the typed options the shim was started with
+	RuntimeOptions *RuntimeOptions `json:"runtime_options,omitempty"`
+	// SandboxID is the sandbox the container belongs to, if any
+	SandboxID string `json:"sandbox_id,omitempty"`
+	// ExitStatus is the exit status of the init process once stopped
+	ExitStatus uint32 `json:"exit_status,omitempty"`
+	// ExitedAt is when the init process exited
+	ExitedAt time.Time `json:"exited_at,omitempty"`
+	// OOMEvents counts the OOM events of the container
+	OOMEvents int `json:"oom_events,omitempty"`
+	// OOMKilled is set when the init process was killed after running out
+	// of memory
+	OOMKilled bool `json:"oom_killed,omitempty"`
+	// OnExit holds the actions taken once the init process exits, if any
+	OnExit *OnExit `json:"on_exit,omitempty"`
+	// LogURI is where the shim sends the output, when not to the monitor
+	LogURI string `json:"log_uri,omitempty"`
+	// LogFormat is the format of the log of the monitor
+	LogFormat cio.LogFormat `json:"log_format,omitempty"`
+	// LogDriver is journald or syslog when the monitor sends the output
+	// there instead of to its log
+	LogDriver string `json:"log_driver,omitempty"`
+	// LogDropped counts the output left out of the log by its rate limit or
+	// a log driver that could not keep up
+	LogDropped *LogDropped `json:"log_dropped,omitempty"`
+	// FIFODir is the directory of the IO FIFOs of the init process
+	FIFODir string `json:"fifo_dir,omitempty"`
+	// IOStats counts what went through the FIFOs of the init process
+	IOStats *cio.Stats `json:"io_stats,omitempty"`
+	// IOError is the first error copying the IO of the init process
+	IOError string `json:"io_error,omitempty"`
 }
 
 // NewShimManager creates a manager for v2 shims
@@ -71,6 +111,8 @@ func NewShimManager(ctx context.Context, config *ManagerConfig) (*ShimManager, e
 		state:                  config.State,
 		containerdAddress:      config.Address,
 		containerdTTRPCAddress: config.TTRPCAddress,
+		shimLogger:             config.ShimLogger,
+		pools:                  config.Pools,
 		shims:                  runtime.NewTaskList(),
 	}
 	log.G(ctx).Errorf("AAAAA NewShimManager ShimManager %+v", config)
@@ -90,6 +132,8 @@ type ShimManager struct {
 	state                  string
 	containerdAddress      string
 	containerdTTRPCAddress string
+	shimLogger             ShimLogger
+	pools                  map[string]PoolConfig
 	shims                  *runtime.TaskList
 }
 
@@ -113,6 +157,23 @@ func (m *ShimManager) Start(ctx context.Context, id string, opts runtime.CreateO
 	log.G(ctx).Errorf("AAAAA ShimManager Start bundle %+v", bundle)
 	log.G(ctx).Errorf("AAAAA ShimManager Start opts %+v", opts)
 
+	// This container belongs to a sandbox that already has a shim running,
+	// so reuse its connection instead of launching a new shim binary.
+	if opts.SandboxID != "" && opts.SandboxID != id {
+		return m.joinSandbox(ctx, bundle, opts.SandboxID)
+	}
+
+	// Take an idle shim from the pool of the runtime, and only start one when
+	// the pool is empty.
+	if pool, ok := m.pools[opts.Runtime]; ok && pool.Size > 0 {
+		shimTask, err := m.bindPooledShim(ctx, bundle, pool)
+		if err != nil {
+			log.G(ctx).WithError(err).Warn("failed to take shim from pool, starting a new one")
+		} else if shimTask != nil {
+			return shimTask, nil
+		}
+	}
+
 	shim, err := m.startShim(ctx, bundle, id, opts)
 	if err != nil {
 		return nil, err
@@ -137,6 +198,52 @@ func (m *ShimManager) Start(ctx context.Context, id string, opts runtime.CreateO
 	return shimTask, nil
 }
 
+func (m *ShimManager) joinSandbox(ctx context.Context, bundle *Bundle, sandboxID string) (ShimProcess, error) {
+	sandboxBundle, err := m.bundlePath(ctx, sandboxID)
+	if err != nil {
+		return nil, fmt.Errorf("can't find sandbox %s: %w", sandboxID, err)
+	}
+
+	// Write sandbox ID this task belongs to.
+	if err := os.WriteFile(filepath.Join(bundle.Path, "sandbox"), []byte(sandboxID), 0600); err != nil {
+		return nil, err
+	}
+
+	address, err := shimbinary.ReadAddress(filepath.Join(sandboxBundle, "address"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get socket address for sandbox %q: %w", sandboxID, err)
+	}
+
+	// Use sandbox's socket address to handle task requests for this container.
+	if err := shimbinary.WriteAddress(filepath.Join(bundle.Path, "address"), address); err != nil {
+		return nil, err
+	}
+
+	shimTask, err := LoadShim(ctx, bundle, func() { m.markUnknown(ctx, bundle.ID) })
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sandbox task %q: %w", sandboxID, err)
+	}
+
+	if err := m.shims.Add(ctx, shimTask); err != nil {
+		return nil, fmt.Errorf("failed to add task: %w", err)
+	}
+
+	return shimTask, nil
+}
+
+// bundlePath returns the bundle path of a running task, falling back to the
+// state saved on disk when the task was started by another runs process.
+func (m *ShimManager) bundlePath(ctx context.Context, id string) (string, error) {
+	if proc, err := m.shims.Get(ctx, id); err == nil {
+		return proc.(*shimTask).Bundle(), nil
+	}
+	state, err := LoadState(m.state, id)
+	if err != nil {
+		return "", err
+	}
+	return state.Bundle, nil
+}
+
 func (m *ShimManager) startShim(ctx context.Context, bundle *Bundle, id string, opts runtime.CreateOpts) (*shim, error) {
 	ns, err := namespaces.NamespaceRequired(ctx)
 	if err != nil {
@@ -160,21 +267,27 @@ func (m *ShimManager) startShim(ctx context.Context, bundle *Bundle, id string,
 		runtime:      runtimePath,
 		address:      m.containerdAddress,
 		ttrpcAddress: m.containerdTTRPCAddress,
+		logger:       m.shimLogger,
+		logPath:      ShimLogPath(m.state, id),
 	})
 
 	log.G(ctx).Errorf("AAAAA2 ShimManager startShim opts %+v", opts)
+	// A lost connection may only be a restarting shim, which is reconnected
+	// to on the next call.
 	shim, err := b.Start(ctx, protobuf.FromAny(topts), func() {
 		log.G(ctx).WithField("id", id).Info("shim disconnected")
-
+		m.markUnknown(ctx, id)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("start failed: %w", err)
+	}
+	shim.onDead = func() {
 		cleanupAfterDeadShim(context.Background(), id, ns, m.shims, b)
 		// Remove self from the runtime task list. Even though the cleanupAfterDeadShim()
 		// would publish taskExit event, but the shim.Delete() would always failed with ttrpc
 		// disconnect and there is no chance to remove this dead task from runtime task lists.
 		// Thus it's better to delete it here.
 		m.shims.Delete(ctx, id)
-	})
-	if err != nil {
-		return nil, fmt.Errorf("start failed: %w", err)
 	}
 
 	log.G(ctx).Errorf("AAAAA new shim %+v", shim)
@@ -183,6 +296,13 @@ func (m *ShimManager) startShim(ctx context.Context, bundle *Bundle, id string,
 }
 
 func (m *ShimManager) resolveRuntimePath(runtime string) (string, error) {
+	return ResolveRuntimePath(runtime)
+}
+
+// ResolveRuntimePath returns the absolute path of the shim binary for a
+// runtime name such as io.containerd.kata.v2, or checks that an absolute path
+// to a shim binary exists.
+func ResolveRuntimePath(runtime string) (string, error) {
 	if runtime == "" {
 		return "", fmt.Errorf("no runtime name")
 	}
@@ -253,7 +373,7 @@ func (m *ShimManager) resolveRuntimePath(runtime string) (string, error) {
 
 // cleanupShim attempts to properly delete and cleanup shim after error
 func (m *ShimManager) cleanupShim(shim *shim) {
-	dctx, cancel := timeout.WithContext(context.Background(), cleanupTimeout)
+	dctx, cancel := timeout.WithContext(context.Background(), CleanupTimeout)
 	defer cancel()
 
 	_ = shim.delete(dctx)
@@ -271,6 +391,68 @@ func (m *ShimManager) Get(ctx context.Context, id string) (ShimProcess, error) {
 	return shimTask, nil
 }
 
+// Load connects to the shim of a container created by another runs process
+// and adds it to the manager.
+func (m *ShimManager) Load(ctx context.Context, id string) (ShimProcess, error) {
+	state, err := LoadState(m.state, id)
+	if err != nil {
+		return nil, err
+	}
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bundle := &Bundle{
+		ID:        id,
+		Path:      state.Bundle,
+		Namespace: ns,
+	}
+	shimTask, err := LoadShim(ctx, bundle, func() { m.markUnknown(ctx, id) })
+	if err != nil {
+		return nil, err
+	}
+	if err := m.shims.Add(ctx, shimTask); err != nil {
+		shimTask.Close()
+		return nil, fmt.Errorf("failed to add task: %w", err)
+	}
+	return shimTask, nil
+}
+
+// markUnknown is called when the connection to the shim of a container is
+// lost, the container is kept with an unknown status rather than dropped.
+func (m *ShimManager) markUnknown(ctx context.Context, id string) {
+	log.G(ctx).WithField("id", id).Warn("lost connection to shim")
+	if err := updateStatus(m.state, id, UnknownStatus); err != nil && !os.IsNotExist(err) {
+		log.G(ctx).WithError(err).WithField("id", id).Warn("failed to mark container state unknown")
+	}
+}
+
+// sandboxed reports whether other containers still share the sandbox of the
+// given container, in which case deleting it must not shut the shim down.
+func (m *ShimManager) sandboxed(id string) (bool, error) {
+	members, err := SandboxMembers(m.state, id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return len(members) > 0, nil
+}
+
+// isSandbox reports whether the container is a sandbox, that is whether it
+// did not join the sandbox of another container.
+func (m *ShimManager) isSandbox(id string) (bool, error) {
+	state, err := LoadState(m.state, id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return state.SandboxID == "" || state.SandboxID == id, nil
+}
+
 // Delete a runtime task
 func (m *ShimManager) Delete(ctx context.Context, id string) error {
 	log.G(ctx).Errorf("AAAAA ShimManager Delete %+v", id)
@@ -318,10 +500,12 @@ func NewTaskManager(shims *ShimManager) *TaskManager {
 // Create launches new shim instance and creates new task
 func (m *TaskManager) Create(ctx context.Context, taskID string, opts runtime.CreateOpts) (runtime.Task, error) {
 	log.G(ctx).Errorf("AAAAA TaskManager Create %+v", opts)
+	started := time.Now()
 	process, err := m.manager.Start(ctx, taskID, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to start shim: %w", err)
 	}
+	shimStarted := time.Now()
 
 	// Cast to shim task and call task service to create a new container task instance.
 	// This will not be required once shim service / client implemented.
@@ -334,14 +518,15 @@ func (m *TaskManager) Create(ctx context.Context, taskID string, opts runtime.Cr
 		// NOTE: ctx contains required namespace information.
 		m.manager.shims.Delete(ctx, taskID)
 
-		dctx, cancel := timeout.WithContext(context.Background(), cleanupTimeout)
+		dctx, cancel := timeout.WithContext(context.Background(), CleanupTimeout)
 		defer cancel()
 
 		sandboxed := false
 		_, errShim := shim.Delete(dctx, sandboxed, func(context.Context, string) {})
 		if errShim != nil {
+			log.G(ctx).WithError(timeoutError(dctx, CleanupTimeout, errShim)).Warn("failed to delete shim after create failure")
 			if errdefs.IsDeadlineExceeded(errShim) {
-				dctx, cancel = timeout.WithContext(context.Background(), cleanupTimeout)
+				dctx, cancel = timeout.WithContext(context.Background(), CleanupTimeout)
 				defer cancel()
 			}
 
@@ -352,6 +537,12 @@ func (m *TaskManager) Create(ctx context.Context, taskID string, opts runtime.Cr
 		return nil, fmt.Errorf("failed to create shim task: %w", err)
 	}
 	log.G(ctx).Errorf("AAAAA call shim Create end ok")
+	log.G(ctx).WithFields(logrus.Fields{
+		"id":          taskID,
+		"pooled":      pooledShimBundle(shim.Bundle()) != "",
+		"shim_start":  shimStarted.Sub(started),
+		"task_create": time.Since(shimStarted),
+	}).Info("task created")
 	pid, _ := shim.Pids(ctx)
 	// log.G(ctx).Errorf("id is %v id is  %v", p.id, p.shim.ID())
 	// response, err := shim.task.Delete(ctx, &task.StateRequest{
@@ -369,7 +560,7 @@ func (m *TaskManager) Create(ctx context.Context, taskID string, opts runtime.Cr
 	log.G(ctx).Errorf("responce is %v", state)
 	// log.G(ctx).Errorf("responce is %v", state.Pid)
 
-	if err := saveContainerState(ctx, taskID, state.Status, state.Pid, opts); err != nil {
+	if err := saveContainerState(ctx, m.manager.state, taskID, state.Status, state.Pid, opts); err != nil {
 		return nil, err
 	}
 
@@ -378,7 +569,28 @@ func (m *TaskManager) Create(ctx context.Context, taskID string, opts runtime.Cr
 
 // Get a specific task
 func (m *TaskManager) Get(ctx context.Context, id string) (runtime.Task, error) {
-	return m.manager.shims.Get(ctx, id)
+	shimTask, err := m.task(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return shimTask, nil
+}
+
+// task returns the shim task of the given container, connecting to its shim
+// when it was started by another runs process.
+func (m *TaskManager) task(ctx context.Context, id string) (*shimTask, error) {
+	item, err := m.manager.shims.Get(ctx, id)
+	if err == nil {
+		return item.(*shimTask), nil
+	}
+	if !errors.Is(err, runtime.ErrTaskNotExists) {
+		return nil, err
+	}
+	proc, err := m.manager.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return proc.(*shimTask), nil
 }
 
 // Tasks lists all tasks
@@ -388,13 +600,25 @@ func (m *TaskManager) Tasks(ctx context.Context, all bool) ([]runtime.Task, erro
 
 // Delete deletes the task and shim instance
 func (m *TaskManager) Delete(ctx context.Context, taskID string) (*runtime.Exit, error) {
-	item, err := m.manager.shims.Get(ctx, taskID)
+	shimTask, err := m.task(ctx, taskID)
 	if err != nil {
 		return nil, err
 	}
 
-	sandboxed := false
-	shimTask := item.(*shimTask)
+	// The shim of a sandbox is shared by all of its containers and is only
+	// shut down together with the last of them.
+	sandboxed, err := m.manager.sandboxed(taskID)
+	if err != nil {
+		return nil, err
+	}
+	// The sandbox itself goes last.
+	if sandboxed {
+		if isSandbox, err := m.manager.isSandbox(taskID); err != nil {
+			return nil, err
+		} else if isSandbox {
+			return nil, fmt.Errorf("sandbox %s still has containers: %w", taskID, errdefs.ErrFailedPrecondition)
+		}
+	}
 	exit, err := shimTask.Delete(ctx, sandboxed, func(ctx context.Context, id string) {
 		m.manager.shims.Delete(ctx, id)
 	})
@@ -403,6 +627,14 @@ func (m *TaskManager) Delete(ctx context.Context, taskID string) (*runtime.Exit,
 		return nil, fmt.Errorf("failed to delete task: %w", err)
 	}
 
+	// A shim taken from a pool was started in a directory of its own.
+	if path := pooledShimBundle(shimTask.Bundle()); path != "" && !sandboxed {
+		if err := os.RemoveAll(path); err != nil {
+			log.G(ctx).WithError(err).Warn("failed to remove pooled shim directory")
+		}
+		os.Remove(filepath.Join(shimTask.Bundle(), shimBundleFilename))
+	}
+
 	return exit, nil
 }
 
@@ -410,9 +642,9 @@ const (
 	stateFilename = "state.json"
 )
 
-func saveContainerState(ctx sctx.Context, taskID string, status runtime.Status, pid uint32, opts runtime.CreateOpts) error {
+func saveContainerState(ctx sctx.Context, root, taskID string, status runtime.Status, pid uint32, opts runtime.CreateOpts) error {
 	log.G(ctx).Errorf("AAAAA TaskManager save %+v", opts)
-	containerRoot, err := securejoin.SecureJoin("/run/runs", taskID)
+	containerRoot, err := securejoin.SecureJoin(root, taskID)
 	// if err != nil {
 	// 	return err
 	// }
@@ -420,7 +652,6 @@ func saveContainerState(ctx sctx.Context, taskID string, status runtime.Status,
 	// os.MkdirAll(containerRoot, 0711)
 	// os.Chown(containerRoot, unix.Geteuid(), unix.Getegid())
 	tmpFile, err := os.CreateTemp(containerRoot, "state.json")
-	fmt.Println("the file name is ", tmpFile.Name())
 	if err != nil {
 		return err
 	}
@@ -436,11 +667,23 @@ func saveContainerState(ctx sctx.Context, taskID string, status runtime.Status,
 		return err
 	}
 
+	topts := opts.TaskOptions
+	if topts == nil || topts.GetValue() == nil {
+		topts = opts.RuntimeOptions
+	}
+	runtimeOpts, err := recordRuntimeOptions(topts)
+	if err != nil {
+		return fmt.Errorf("failed to record runtime options: %w", err)
+	}
+
 	state := State{
 		InitProcessPid: int(pid),
 		Status:         status,
 		Bundle:         path,
 		Created:        time.Now().UTC(),
+		Runtime:        opts.Runtime,
+		RuntimeOptions: runtimeOpts,
+		SandboxID:      opts.SandboxID,
 	}
 
 	util.WriteJSON(tmpFile, state)
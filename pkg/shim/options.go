@@ -0,0 +1,191 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package shim
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	runtimeoptions "github.com/containerd/containerd/pkg/runtimeoptions/v1"
+	runcoptions "github.com/containerd/containerd/runtime/v2/runc/options"
+	"github.com/containerd/typeurl"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	// RuncRuntime is the runtime name of the runc v2 shim.
+	RuncRuntime = "io.containerd.runc.v2"
+	// KataRuntime is the runtime name of the kata v2 shim.
+	KataRuntime = "io.containerd.kata.v2"
+)
+
+// RuntimeOptions records the typed options a container's shim was created with.
+type RuntimeOptions struct {
+	// TypeURL of the options message
+	TypeURL string `json:"type_url"`
+	// Options is the JSON form of the options message
+	Options json.RawMessage `json:"options"`
+}
+
+// newOptionsMessage returns an empty options message of the type understood
+// by the shim of the given runtime. runc shims take the runc Options message,
+// every other shim (kata included) takes the generic CRI runtime options that
+// only carry a config file path.
+func newOptionsMessage(runtime string) proto.Message {
+	switch runtime {
+	case "io.containerd.runc.v1", RuncRuntime:
+		return &runcoptions.Options{}
+	default:
+		return &runtimeoptions.Options{}
+	}
+}
+
+// NewRuntimeOptions resolves an options file and key=value overrides into the
+// typed options message for the given runtime. The file is decoded as the JSON
+// form of the message; overrides are applied on top of it and may name a field
+// by its proto name, its JSON name or its Go name. A nil message is returned
+// when neither a file nor overrides are provided.
+func NewRuntimeOptions(runtime, path string, overrides map[string]string) (proto.Message, error) {
+	if path == "" && len(overrides) == 0 {
+		return nil, nil
+	}
+	msg := newOptionsMessage(runtime)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read runtime options: %w", err)
+		}
+		if err := protojson.Unmarshal(data, msg); err != nil {
+			return nil, fmt.Errorf("failed to decode runtime options %s: %w", path, err)
+		}
+	}
+	m := msg.ProtoReflect()
+	for k, v := range overrides {
+		fd := findOptionField(m.Descriptor().Fields(), k)
+		if fd == nil {
+			return nil, fmt.Errorf("unknown runtime option %q for %s", k, m.Descriptor().FullName())
+		}
+		value, err := parseOptionValue(fd, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for runtime option %q: %w", k, err)
+		}
+		m.Set(fd, value)
+	}
+	return msg, nil
+}
+
+// ParseRuntimeOptions splits a list of key=value pairs.
+func ParseRuntimeOptions(values []string) (map[string]string, error) {
+	overrides := make(map[string]string, len(values))
+	for _, kv := range values {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("runtime option %q must be in the form key=value", kv)
+		}
+		overrides[parts[0]] = parts[1]
+	}
+	return overrides, nil
+}
+
+func findOptionField(fields protoreflect.FieldDescriptors, name string) protoreflect.FieldDescriptor {
+	if fd := fields.ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	if fd := fields.ByJSONName(name); fd != nil {
+		return fd
+	}
+	normalize := func(s string) string {
+		return strings.ToLower(strings.ReplaceAll(s, "_", ""))
+	}
+	for i := 0; i < fields.Len(); i++ {
+		if fd := fields.Get(i); normalize(string(fd.Name())) == normalize(name) {
+			return fd
+		}
+	}
+	return nil
+}
+
+func parseOptionValue(fd protoreflect.FieldDescriptor, v string) (protoreflect.Value, error) {
+	if fd.IsList() || fd.IsMap() {
+		return protoreflect.Value{}, fmt.Errorf("field %s cannot be set from the command line", fd.Name())
+	}
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(v), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfBool(b), nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfUint32(uint32(n)), nil
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfUint64(n), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfInt32(int32(n)), nil
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfInt64(n), nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported field type %s", fd.Kind())
+}
+
+// recordRuntimeOptions converts the options passed to a shim back into their
+// JSON form so they can be persisted with the container state.
+func recordRuntimeOptions(opts typeurl.Any) (*RuntimeOptions, error) {
+	if opts == nil || opts.GetValue() == nil {
+		return nil, nil
+	}
+	v, err := typeurl.UnmarshalAny(opts)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if msg, ok := v.(proto.Message); ok {
+		data, err = protojson.Marshal(msg)
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &RuntimeOptions{
+		TypeURL: opts.GetTypeUrl(),
+		Options: data,
+	}, nil
+}
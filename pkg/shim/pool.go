@@ -0,0 +1,393 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package shim
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/protobuf"
+	shimbinary "github.com/containerd/containerd/runtime/v2/shim"
+	"github.com/containerd/typeurl"
+	"github.com/kata-contrib/runs/pkg/util"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	// poolDirname holds the pools under the state root. Container ids must
+	// start with an alphanumeric character, so it never clashes with one.
+	poolDirname = ".pool"
+	// shimsDirname holds the directories pooled shims are started in. A
+	// shim keeps its directory for its whole life, the pools only link to
+	// it while it is idle.
+	shimsDirname   = ".shims"
+	pooledFilename = "pooled.json"
+	// shimBundleFilename records, in the bundle of a container, the
+	// directory its pooled shim was started in.
+	shimBundleFilename = "shim-bundle"
+)
+
+// PoolConfig configures the pool of idle shims kept for a runtime handler.
+type PoolConfig struct {
+	// Name is the runtime handler the pool belongs to
+	Name string
+	// Runtime is the shim runtime name or path
+	Runtime string
+	// Options are the typed options the shims are started with
+	Options typeurl.Any
+	// Size is the number of idle shims to keep
+	Size int
+	// IdleTTL is how long an idle shim is kept before it is replaced, zero
+	// keeps it until it is used
+	IdleTTL time.Duration
+	// Refill starts a replacement whenever a shim is taken from the pool
+	Refill bool
+}
+
+// PooledShim is an idle shim waiting in a pool.
+type PooledShim struct {
+	ID      string    `json:"id"`
+	Runtime string    `json:"runtime"`
+	Address string    `json:"address"`
+	Created time.Time `json:"created"`
+	// Startup is how long the shim took to start, the time saved by
+	// taking it from the pool
+	Startup time.Duration `json:"startup"`
+}
+
+// Expired reports whether the shim has been idle for longer than ttl.
+func (p *PooledShim) Expired(ttl time.Duration) bool {
+	return ttl > 0 && time.Since(p.Created) > ttl
+}
+
+func (m *ShimManager) poolDir(name string) string {
+	return filepath.Join(m.state, poolDirname, strings.ReplaceAll(name, "/", "_"))
+}
+
+func (m *ShimManager) pooledShimDir(id string) string {
+	return filepath.Join(m.state, poolDirname, shimsDirname, id)
+}
+
+// PoolStatus returns the idle shims in the pool of a runtime handler, oldest
+// first.
+func (m *ShimManager) PoolStatus(name string) ([]*PooledShim, error) {
+	dir := m.poolDir(name)
+	list, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var shims []*PooledShim
+	for _, item := range list {
+		if item.Type()&os.ModeSymlink == 0 || strings.HasPrefix(item.Name(), ".") {
+			continue
+		}
+		p, err := readPooledShim(filepath.Join(dir, item.Name()))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// Taken while listing.
+				continue
+			}
+			return nil, err
+		}
+		shims = append(shims, p)
+	}
+	sort.Slice(shims, func(i, j int) bool {
+		return shims[i].Created.Before(shims[j].Created)
+	})
+	return shims, nil
+}
+
+// FillPool replaces the expired shims of a pool and starts new ones until it
+// holds config.Size idle shims.
+func (m *ShimManager) FillPool(ctx context.Context, config PoolConfig) error {
+	shims, err := m.PoolStatus(config.Name)
+	if err != nil {
+		return err
+	}
+	idle := 0
+	for _, p := range shims {
+		if !p.Expired(config.IdleTTL) {
+			idle++
+			continue
+		}
+		if err := m.stopPooledShim(ctx, config.Name, p.ID); err != nil {
+			log.G(ctx).WithError(err).WithField("shim", p.ID).Warn("failed to stop expired pooled shim")
+		}
+	}
+	for ; idle < config.Size; idle++ {
+		p, err := m.startPooledShim(ctx, config)
+		if err != nil {
+			return fmt.Errorf("failed to start pooled shim: %w", err)
+		}
+		log.G(ctx).WithField("shim", p.ID).WithField("duration", p.Startup).Info("pooled shim started")
+	}
+	return nil
+}
+
+// DrainPool stops all idle shims of a pool.
+func (m *ShimManager) DrainPool(ctx context.Context, name string) error {
+	shims, err := m.PoolStatus(name)
+	if err != nil {
+		return err
+	}
+	for _, p := range shims {
+		if err := m.stopPooledShim(ctx, name, p.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startPooledShim starts an idle shim in a directory of its own. The shim is
+// only linked into its pool once it is ready, so that it is never taken half
+// started.
+func (m *ShimManager) startPooledShim(ctx context.Context, config PoolConfig) (_ *PooledShim, retErr error) {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newPoolID()
+	if err != nil {
+		return nil, err
+	}
+	path := m.pooledShimDir(id)
+	if err := os.MkdirAll(path, 0711); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			os.RemoveAll(path)
+		}
+	}()
+
+	// Shims such as kata's read the spec of their bundle when started, give
+	// them an empty one: the container's own is passed on create.
+	spec, err := json.Marshal(specs.Spec{Version: specs.Version})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(path, configFilename), spec, 0600); err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	runtimePath, err := m.resolveRuntimePath(config.Runtime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve runtime path: %w", err)
+	}
+	bundle := &Bundle{
+		ID:        id,
+		Path:      path,
+		Namespace: ns,
+	}
+	b := shimBinary(bundle, shimBinaryConfig{
+		runtime:      runtimePath,
+		address:      m.containerdAddress,
+		ttrpcAddress: m.containerdTTRPCAddress,
+		logger:       m.shimLogger,
+		logPath:      filepath.Join(path, shimLogFilename),
+	})
+	s, err := b.Start(ctx, protobuf.FromAny(config.Options), func() {})
+	if err != nil {
+		return nil, err
+	}
+	// Pooled shims outlive this process, only the connection is closed.
+	defer s.Close()
+
+	address, err := shimbinary.ReadAddress(filepath.Join(path, "address"))
+	if err != nil {
+		b.Delete(ctx)
+		return nil, err
+	}
+	p := &PooledShim{
+		ID:      id,
+		Runtime: config.Runtime,
+		Address: address,
+		Created: time.Now().UTC(),
+		Startup: time.Since(started),
+	}
+	if err := m.addPooledShim(config.Name, path, p); err != nil {
+		b.Delete(ctx)
+		return nil, err
+	}
+	return p, nil
+}
+
+// addPooledShim records the shim p, started in path, and links it into the
+// pool of a runtime handler.
+func (m *ShimManager) addPooledShim(name, path string, p *PooledShim) error {
+	f, err := os.Create(filepath.Join(path, pooledFilename))
+	if err != nil {
+		return err
+	}
+	err = util.WriteJSON(f, p)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	dir := m.poolDir(name)
+	if err := os.MkdirAll(dir, 0711); err != nil {
+		return err
+	}
+	return os.Symlink(path, filepath.Join(dir, p.ID))
+}
+
+// stopPooledShim shuts an idle shim down and removes its directory.
+func (m *ShimManager) stopPooledShim(ctx context.Context, name, id string) error {
+	path, err := m.claimPooledShim(name, id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Taken in the meantime.
+			return nil
+		}
+		return err
+	}
+	return shutdownClaimedShim(ctx, id, path)
+}
+
+// shutdownClaimedShim shuts down a shim claimed from its pool and removes its
+// directory.
+func shutdownClaimedShim(ctx context.Context, id, path string) error {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err == nil {
+		var s *shimTask
+		if s, err = LoadShim(ctx, &Bundle{ID: id, Path: path, Namespace: ns}, func() {}); err == nil {
+			err = s.waitShutdown(ctx)
+			s.Close()
+		}
+	}
+	if err != nil {
+		log.G(ctx).WithError(err).WithField("shim", id).Warn("failed to shut down pooled shim")
+	}
+	return os.RemoveAll(path)
+}
+
+// claimPooledShim takes an idle shim out of its pool and returns the
+// directory it runs in. Only one unlink of the pool link succeeds, so a shim
+// is claimed by one runs process only. The directory itself stays where the
+// shim was started: its sockets, address and log keep their paths.
+func (m *ShimManager) claimPooledShim(name, id string) (string, error) {
+	if err := os.Remove(filepath.Join(m.poolDir(name), id)); err != nil {
+		return "", err
+	}
+	return m.pooledShimDir(id), nil
+}
+
+// bindPooledShim takes an idle shim from the pool and binds it to the
+// container of the bundle. It returns nil if the pool has no usable shim.
+func (m *ShimManager) bindPooledShim(ctx context.Context, bundle *Bundle, config PoolConfig) (ShimProcess, error) {
+	shims, err := m.PoolStatus(config.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range shims {
+		if p.Expired(config.IdleTTL) {
+			continue
+		}
+		path, err := m.claimPooledShim(config.Name, p.ID)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		shimTask, err := m.bindClaimedShim(ctx, bundle, p, path)
+		if err != nil {
+			// Nothing else can use the claimed shim.
+			os.Remove(filepath.Join(bundle.Path, shimBundleFilename))
+			os.Remove(ShimLogPath(m.state, bundle.ID))
+			shutdownClaimedShim(ctx, p.ID, path)
+			return nil, err
+		}
+		log.G(ctx).WithField("id", bundle.ID).WithField("shim", p.ID).Info("bound pooled shim")
+		return shimTask, nil
+	}
+	return nil, nil
+}
+
+// bindClaimedShim binds the pooled shim p, running in path, to the container
+// of the bundle.
+func (m *ShimManager) bindClaimedShim(ctx context.Context, bundle *Bundle, p *PooledShim, path string) (ShimProcess, error) {
+	if err := os.WriteFile(filepath.Join(bundle.Path, shimBundleFilename), []byte(path), 0600); err != nil {
+		return nil, err
+	}
+	if err := shimbinary.WriteAddress(filepath.Join(bundle.Path, "address"), p.Address); err != nil {
+		return nil, err
+	}
+	// Keep the shim log next to the container's state, where runs logs
+	// looks for it.
+	logPath := ShimLogPath(m.state, bundle.ID)
+	if err := os.Symlink(filepath.Join(path, shimLogFilename), logPath); err != nil && !os.IsExist(err) {
+		log.G(ctx).WithError(err).Warn("failed to link pooled shim log")
+	}
+
+	shimTask, err := LoadShim(ctx, bundle, func() { m.markUnknown(ctx, bundle.ID) })
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pooled shim %s: %w", p.ID, err)
+	}
+	if err := m.shims.Add(ctx, shimTask); err != nil {
+		shimTask.Close()
+		return nil, fmt.Errorf("failed to add task: %w", err)
+	}
+	return shimTask, nil
+}
+
+// pooledShimBundle returns the directory the pooled shim of a container was
+// started in, or an empty string if its shim was not taken from a pool.
+func pooledShimBundle(bundle string) string {
+	data, err := os.ReadFile(filepath.Join(bundle, shimBundleFilename))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func readPooledShim(path string) (*PooledShim, error) {
+	f, err := os.Open(filepath.Join(path, pooledFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var p PooledShim
+	if err := json.NewDecoder(f).Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func newPoolID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "pool-" + hex.EncodeToString(b), nil
+}
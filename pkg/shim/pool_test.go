@@ -0,0 +1,152 @@
+package shim
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/containerd/namespaces"
+	ptypes "github.com/containerd/containerd/protobuf/types"
+	"github.com/containerd/containerd/runtime"
+	client "github.com/containerd/containerd/runtime/v2/shim"
+	"github.com/containerd/ttrpc"
+)
+
+// fakeShim serves the calls runs makes to a shim and records them.
+type fakeShim struct {
+	task.TaskService
+
+	mu       sync.Mutex
+	execs    []string
+	deleted  []string
+	shutdown bool
+}
+
+func (s *fakeShim) Connect(ctx context.Context, r *task.ConnectRequest) (*task.ConnectResponse, error) {
+	return &task.ConnectResponse{ShimPid: uint32(os.Getpid()), TaskPid: 1}, nil
+}
+
+func (s *fakeShim) Exec(ctx context.Context, r *task.ExecProcessRequest) (*ptypes.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.execs = append(s.execs, r.ID+"/"+r.ExecID)
+	return &ptypes.Empty{}, nil
+}
+
+func (s *fakeShim) Delete(ctx context.Context, r *task.DeleteRequest) (*task.DeleteResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleted = append(s.deleted, r.ID)
+	return &task.DeleteResponse{}, nil
+}
+
+func (s *fakeShim) Shutdown(ctx context.Context, r *task.ShutdownRequest) (*ptypes.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = true
+	return &ptypes.Empty{}, nil
+}
+
+// serveFakeShim starts a fake shim in path the way startPooledShim starts
+// one, and returns its address and server.
+func serveFakeShim(t *testing.T, path string, s *fakeShim) (string, *ttrpc.Server) {
+	t.Helper()
+	if err := os.MkdirAll(path, 0711); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(path, "s")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := ttrpc.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.RegisterTaskService(server, s)
+	go server.Serve(context.Background(), l)
+	t.Cleanup(func() { server.Close() })
+
+	address := "unix://" + socket
+	if err := client.WriteAddress(filepath.Join(path, "address"), address); err != nil {
+		t.Fatal(err)
+	}
+	return address, server
+}
+
+func TestPooledShimClaimExecDelete(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), "default")
+	m := &ShimManager{
+		state: t.TempDir(),
+		shims: runtime.NewTaskList(),
+	}
+	pool := PoolConfig{Name: "kata", Size: 1}
+
+	fake := &fakeShim{}
+	id := "pool-test"
+	path := m.pooledShimDir(id)
+	address, _ := serveFakeShim(t, path, fake)
+	if err := m.addPooledShim(pool.Name, path, &PooledShim{ID: id, Address: address, Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if shims, err := m.PoolStatus(pool.Name); err != nil || len(shims) != 1 || shims[0].ID != id {
+		t.Fatalf("pool before claim: %v, %v", shims, err)
+	}
+
+	bundle := &Bundle{ID: "c1", Path: t.TempDir(), Namespace: "default"}
+	if err := os.MkdirAll(filepath.Join(m.state, bundle.ID), 0711); err != nil {
+		t.Fatal(err)
+	}
+	proc, err := m.bindPooledShim(ctx, bundle, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proc == nil {
+		t.Fatal("no shim taken from the pool")
+	}
+
+	if shims, err := m.PoolStatus(pool.Name); err != nil || len(shims) != 0 {
+		t.Fatalf("pool after claim: %v, %v", shims, err)
+	}
+	if _, err := m.claimPooledShim(pool.Name, id); !os.IsNotExist(err) {
+		t.Fatalf("claimed twice: %v", err)
+	}
+	// The shim keeps running where it was started.
+	if got := pooledShimBundle(bundle.Path); got != path {
+		t.Fatalf("shim bundle %q, want %q", got, path)
+	}
+	if _, err := os.Stat(filepath.Join(path, "address")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := proc.(*shimTask).Exec(ctx, "e1", runtime.ExecOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewTaskManager(m).Delete(ctx, bundle.ID); err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if got := strings.Join(fake.execs, ","); got != "c1/e1" {
+		t.Errorf("execs %q, want c1/e1", got)
+	}
+	if got := strings.Join(fake.deleted, ","); got != "c1" {
+		t.Errorf("deleted %q, want c1", got)
+	}
+	if !fake.shutdown {
+		t.Error("shim not shut down")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("pooled shim directory left behind: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(bundle.Path, shimBundleFilename)); !os.IsNotExist(err) {
+		t.Errorf("shim bundle file left behind: %v", err)
+	}
+}
@@ -38,7 +38,7 @@ func (p *process) ID() string {
 }
 
 func (p *process) Kill(ctx context.Context, signal uint32, _ bool) error {
-	_, err := p.shim.task.Kill(ctx, &task.KillRequest{
+	_, err := p.shim.taskService().Kill(ctx, &task.KillRequest{
 		Signal: signal,
 		ID:     p.shim.ID(),
 		ExecID: p.id,
@@ -67,9 +67,13 @@ func statusFromProto(from tasktypes.Status) runtime.Status {
 }
 
 func (p *process) State(ctx context.Context) (runtime.State, error) {
-	response, err := p.shim.task.State(ctx, &task.StateRequest{
-		ID:     p.shim.ID(),
-		ExecID: p.id,
+	var response *task.StateResponse
+	err := p.shim.retry(ctx, func(t task.TaskService) (err error) {
+		response, err = t.State(ctx, &task.StateRequest{
+			ID:     p.shim.ID(),
+			ExecID: p.id,
+		})
+		return err
 	})
 	if err != nil {
 		if !errors.Is(err, ttrpc.ErrClosed) {
@@ -91,7 +95,7 @@ func (p *process) State(ctx context.Context) (runtime.State, error) {
 
 // ResizePty changes the side of the process's PTY to the provided width and height
 func (p *process) ResizePty(ctx context.Context, size runtime.ConsoleSize) error {
-	_, err := p.shim.task.ResizePty(ctx, &task.ResizePtyRequest{
+	_, err := p.shim.taskService().ResizePty(ctx, &task.ResizePtyRequest{
 		ID:     p.shim.ID(),
 		ExecID: p.id,
 		Width:  size.Width,
@@ -105,7 +109,7 @@ func (p *process) ResizePty(ctx context.Context, size runtime.ConsoleSize) error
 
 // CloseIO closes the provided IO pipe for the process
 func (p *process) CloseIO(ctx context.Context) error {
-	_, err := p.shim.task.CloseIO(ctx, &task.CloseIORequest{
+	_, err := p.shim.taskService().CloseIO(ctx, &task.CloseIORequest{
 		ID:     p.shim.ID(),
 		ExecID: p.id,
 		Stdin:  true,
@@ -118,7 +122,7 @@ func (p *process) CloseIO(ctx context.Context) error {
 
 // Start the process
 func (p *process) Start(ctx context.Context) error {
-	_, err := p.shim.task.Start(ctx, &task.StartRequest{
+	_, err := p.shim.taskService().Start(ctx, &task.StartRequest{
 		ID:     p.shim.ID(),
 		ExecID: p.id,
 	})
@@ -130,7 +134,7 @@ func (p *process) Start(ctx context.Context) error {
 
 // Wait on the process to exit and return the exit status and timestamp
 func (p *process) Wait(ctx context.Context) (*runtime.Exit, error) {
-	response, err := p.shim.task.Wait(ctx, &task.WaitRequest{
+	response, err := p.shim.taskService().Wait(ctx, &task.WaitRequest{
 		ID:     p.shim.ID(),
 		ExecID: p.id,
 	})
@@ -144,7 +148,7 @@ func (p *process) Wait(ctx context.Context) (*runtime.Exit, error) {
 }
 
 func (p *process) Delete(ctx context.Context) (*runtime.Exit, error) {
-	response, err := p.shim.task.Delete(ctx, &task.DeleteRequest{
+	response, err := p.shim.taskService().Delete(ctx, &task.DeleteRequest{
 		ID:     p.shim.ID(),
 		ExecID: p.id,
 	})
@@ -23,6 +23,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/containerd/containerd/api/runtime/task/v2"
@@ -41,16 +42,30 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// Names of the shim timeouts in the timeout registry, they can be changed
+// with timeout.Set before talking to shims.
 const (
-	loadTimeout     = "io.containerd.timeout.shim.load"
-	cleanupTimeout  = "io.containerd.timeout.shim.cleanup"
-	shutdownTimeout = "io.containerd.timeout.shim.shutdown"
+	// LoadTimeout bounds connecting to an already running shim
+	LoadTimeout = "io.containerd.timeout.shim.load"
+	// CleanupTimeout bounds cleaning up after a dead or failed shim
+	CleanupTimeout = "io.containerd.timeout.shim.cleanup"
+	// ShutdownTimeout bounds shutting a shim down
+	ShutdownTimeout = "io.containerd.timeout.shim.shutdown"
 )
 
 func init() {
-	timeout.Set(loadTimeout, 5*time.Second)
-	timeout.Set(cleanupTimeout, 5*time.Second)
-	timeout.Set(shutdownTimeout, 3*time.Second)
+	timeout.Set(LoadTimeout, 5*time.Second)
+	timeout.Set(CleanupTimeout, 5*time.Second)
+	timeout.Set(ShutdownTimeout, 3*time.Second)
+}
+
+// timeoutError annotates err with the name of the timeout if it was caused by
+// the expiry of ctx, a context created by timeout.WithContext(name).
+func timeoutError(ctx context.Context, name string, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s timeout of %s expired: %w", name, timeout.Get(name), err)
+	}
+	return err
 }
 
 func loadAddress(path string) (string, error) {
@@ -62,20 +77,18 @@ func loadAddress(path string) (string, error) {
 }
 
 func LoadShim(ctx context.Context, bundle *Bundle, onClose func()) (_ *shimTask, err error) {
-
-	fmt.Printf("id: \n")
 	address, err := loadAddress(filepath.Join(bundle.Path, "address"))
-	fmt.Printf("load shim %s\n", address)
 	if err != nil {
 		return nil, err
 	}
+	log.G(ctx).WithField("id", bundle.ID).WithField("address", address).Debug("loading shim")
+
+	lctx, cancel := timeout.WithContext(ctx, LoadTimeout)
+	defer cancel()
 
-	fmt.Printf("id: \n")
-	conn, err := client.Connect(address, client.AnonReconnectDialer)
-	fmt.Printf("invalid task  %w\n", err)
-	fmt.Printf("5id: \n")
+	conn, err := dialShim(lctx, address)
 	if err != nil {
-		return nil, err
+		return nil, timeoutError(lctx, LoadTimeout, err)
 	}
 	defer func() {
 		if err != nil {
@@ -83,72 +96,75 @@ func LoadShim(ctx context.Context, bundle *Bundle, onClose func()) (_ *shimTask,
 		}
 	}()
 
-	fmt.Printf("4id: \n")
 	shimCtx, cancelShimLog := context.WithCancel(ctx)
 	defer func() {
 		if err != nil {
 			cancelShimLog()
 		}
 	}()
-	f, err := openShimLog(shimCtx, bundle, client.AnonReconnectDialer)
-	if err != nil {
-		return nil, fmt.Errorf("open shim log pipe when reload: %w", err)
-	}
-
-	fmt.Printf("3id: \n")
-	defer func() {
-		if err != nil {
-			f.Close()
-		}
-	}()
-	// open the log pipe and block until the writer is ready
-	// this helps with synchronization of the shim
-	// copy the shim's logs to containerd's output
-	go func() {
-		defer f.Close()
-		_, err := io.Copy(os.Stderr, f)
-		// To prevent flood of error messages, the expected error
-		// should be reset, like os.ErrClosed or os.ErrNotExist, which
-		// depends on platform.
-		err = checkCopyShimLogError(ctx, err)
-		if err != nil {
-			log.G(ctx).WithError(err).Error("copy shim log after reload")
+	// A shim logger started with the shim owns its log pipe and keeps
+	// persisting the logs, reading the pipe here would steal from it.
+	var f io.ReadCloser
+	if !shimLoggerRunning(bundle) {
+		if f, err = openShimLog(shimCtx, bundle, client.AnonReconnectDialer); err != nil {
+			return nil, fmt.Errorf("open shim log pipe when reload: %w", err)
 		}
-	}()
+
+		defer func() {
+			if err != nil {
+				f.Close()
+			}
+		}()
+		// open the log pipe and block until the writer is ready
+		// this helps with synchronization of the shim
+		// copy the shim's logs to containerd's output
+		go func() {
+			defer f.Close()
+			_, err := io.Copy(os.Stderr, f)
+			// To prevent flood of error messages, the expected error
+			// should be reset, like os.ErrClosed or os.ErrNotExist, which
+			// depends on platform.
+			err = checkCopyShimLogError(ctx, err)
+			if err != nil {
+				log.G(ctx).WithError(err).Error("copy shim log after reload")
+			}
+		}()
+	}
+	sh := &shim{
+		bundle:  bundle,
+		address: address,
+		onClose: onClose,
+		pid:     peerPid(conn),
+	}
 	onCloseWithShimLog := func() {
-		onClose()
+		sh.disconnected()
 		cancelShimLog()
-		f.Close()
+		if f != nil {
+			f.Close()
+		}
 	}
 
-	fmt.Printf("2id: \n")
-	client := ttrpc.NewClient(conn, ttrpc.WithOnClose(onCloseWithShimLog))
+	sh.client = ttrpc.NewClient(conn, ttrpc.WithOnClose(onCloseWithShimLog))
 	defer func() {
 		if err != nil {
-			client.Close()
+			sh.Close()
 		}
 	}()
 	s := &shimTask{
-		shim: &shim{
-			bundle: bundle,
-			client: client,
-		},
-		task: task.NewTaskClient(client),
+		shim: sh,
+		task: task.NewTaskClient(sh.client),
 	}
-	ctx, cancel := timeout.WithContext(ctx, loadTimeout)
-	defer cancel()
 
-	fmt.Printf("id: \n")
 	// Check connectivity
-	if _, err := s.PID(ctx); err != nil {
-		return nil, err
+	if _, err := s.PID(lctx); err != nil {
+		return nil, timeoutError(lctx, LoadTimeout, err)
 	}
 	return s, nil
 }
 
 func cleanupAfterDeadShim(ctx context.Context, id, ns string, rt *runtime.TaskList, binaryCall *binary) {
 	ctx = namespaces.WithNamespace(ctx, ns)
-	ctx, cancel := timeout.WithContext(ctx, cleanupTimeout)
+	ctx, cancel := timeout.WithContext(ctx, CleanupTimeout)
 	defer cancel()
 
 	log.G(ctx).Errorf("ns is %+v", ns)
@@ -159,6 +175,7 @@ func cleanupAfterDeadShim(ctx context.Context, id, ns string, rt *runtime.TaskLi
 	}).Warn("cleaning up after shim disconnected")
 	_, err := binaryCall.Delete(ctx)
 	if err != nil {
+		err = timeoutError(ctx, CleanupTimeout, err)
 		log.G(ctx).WithError(err).WithFields(logrus.Fields{
 			"id":        id,
 			"namespace": ns,
@@ -182,7 +199,20 @@ type ShimProcess interface {
 
 type shim struct {
 	bundle *Bundle
+	// address of the shim socket, to reconnect when the connection drops
+	address string
+	// onClose is called when the connection is lost rather than closed
+	onClose func()
+	// onDead, if set, cleans up after the shim once it cannot be reached
+	// anymore and its process is gone
+	onDead   func()
+	deadOnce sync.Once
+
+	mu     sync.Mutex
 	client *ttrpc.Client
+	closed bool
+	// pid of the shim process, learned from the socket
+	pid int
 }
 
 // ID of the shim/task
@@ -199,7 +229,32 @@ func (s *shim) Bundle() string {
 }
 
 func (s *shim) Close() error {
-	return s.client.Close()
+	s.mu.Lock()
+	s.closed = true
+	client := s.client
+	s.mu.Unlock()
+	return client.Close()
+}
+
+// disconnected is the ttrpc close callback of the shim connections. It only
+// reports connections lost to the shim, not ones closed by Close.
+func (s *shim) disconnected() {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if !closed && s.onClose != nil {
+		s.onClose()
+	}
+}
+
+// checkDead runs onDead once the shim process is confirmed gone.
+func (s *shim) checkDead() {
+	s.mu.Lock()
+	pid := s.pid
+	s.mu.Unlock()
+	if s.onDead != nil && processGone(pid) {
+		s.deadOnce.Do(s.onDead)
+	}
 }
 
 func (s *shim) delete(ctx context.Context) error {
@@ -211,7 +266,10 @@ func (s *shim) delete(ctx context.Context) error {
 		result = multierror.Append(result, fmt.Errorf("failed to close ttrpc client: %w", err))
 	}
 
-	if err := s.client.UserOnCloseWait(ctx); err != nil {
+	s.mu.Lock()
+	client := s.client
+	s.mu.Unlock()
+	if err := client.UserOnCloseWait(ctx); err != nil {
 		result = multierror.Append(result, fmt.Errorf("close wait error: %w", err))
 	}
 
@@ -232,11 +290,20 @@ type shimTask struct {
 }
 
 func (s *shimTask) Client() *ttrpc.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.client
 }
 
+// taskService returns the task client of the current connection to the shim.
+func (s *shimTask) taskService() task.TaskService {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.task
+}
+
 func (s *shimTask) Shutdown(ctx context.Context) error {
-	_, err := s.task.Shutdown(ctx, &task.ShutdownRequest{
+	_, err := s.taskService().Shutdown(ctx, &task.ShutdownRequest{
 		ID: s.ID(),
 	})
 	if err != nil && !errors.Is(err, ttrpc.ErrClosed) {
@@ -246,15 +313,19 @@ func (s *shimTask) Shutdown(ctx context.Context) error {
 }
 
 func (s *shimTask) waitShutdown(ctx context.Context) error {
-	ctx, cancel := timeout.WithContext(ctx, shutdownTimeout)
+	ctx, cancel := timeout.WithContext(ctx, ShutdownTimeout)
 	defer cancel()
-	return s.Shutdown(ctx)
+	return timeoutError(ctx, ShutdownTimeout, s.Shutdown(ctx))
 }
 
 // PID of the task
 func (s *shimTask) PID(ctx context.Context) (uint32, error) {
-	response, err := s.task.Connect(ctx, &task.ConnectRequest{
-		ID: s.ID(),
+	var response *task.ConnectResponse
+	err := s.retry(ctx, func(t task.TaskService) (err error) {
+		response, err = t.Connect(ctx, &task.ConnectRequest{
+			ID: s.ID(),
+		})
+		return err
 	})
 	if err != nil {
 		return 0, errdefs.FromGRPC(err)
@@ -264,7 +335,7 @@ func (s *shimTask) PID(ctx context.Context) (uint32, error) {
 }
 
 func (s *shimTask) Delete(ctx context.Context, sandboxed bool, removeTask func(ctx context.Context, id string)) (*runtime.Exit, error) {
-	response, shimErr := s.task.Delete(ctx, &task.DeleteRequest{
+	response, shimErr := s.taskService().Delete(ctx, &task.DeleteRequest{
 		ID: s.ID(),
 	})
 	if shimErr != nil {
@@ -319,13 +390,10 @@ func (s *shimTask) Delete(ctx context.Context, sandboxed bool, removeTask func(c
 }
 
 func (s *shimTask) Create(ctx context.Context, opts runtime.CreateOpts) (runtime.Task, error) {
-
-	fmt.Printf("ggggggggggggggg %+v", opts.Rootfs)
 	topts := opts.TaskOptions
 	if topts == nil || topts.GetValue() == nil {
 		topts = opts.RuntimeOptions
 	}
-	fmt.Printf("ggggggggggggggg %+v", s.ID())
 	request := &task.CreateTaskRequest{
 		ID:         s.ID(),
 		Bundle:     s.bundle.Path,
@@ -336,7 +404,6 @@ func (s *shimTask) Create(ctx context.Context, opts runtime.CreateOpts) (runtime
 		Checkpoint: opts.Checkpoint,
 		Options:    protobuf.FromAny(topts),
 	}
-	fmt.Printf("%+v", opts.Rootfs)
 	for _, m := range opts.Rootfs {
 		request.Rootfs = append(request.Rootfs, &types.Mount{
 			Type:    m.Type,
@@ -345,7 +412,7 @@ func (s *shimTask) Create(ctx context.Context, opts runtime.CreateOpts) (runtime
 		})
 	}
 
-	_, err := s.task.Create(ctx, request)
+	_, err := s.taskService().Create(ctx, request)
 	if err != nil {
 		return nil, errdefs.FromGRPC(err)
 	}
@@ -354,7 +421,7 @@ func (s *shimTask) Create(ctx context.Context, opts runtime.CreateOpts) (runtime
 }
 
 func (s *shimTask) Pause(ctx context.Context) error {
-	if _, err := s.task.Pause(ctx, &task.PauseRequest{
+	if _, err := s.taskService().Pause(ctx, &task.PauseRequest{
 		ID: s.ID(),
 	}); err != nil {
 		return errdefs.FromGRPC(err)
@@ -363,7 +430,7 @@ func (s *shimTask) Pause(ctx context.Context) error {
 }
 
 func (s *shimTask) Resume(ctx context.Context) error {
-	if _, err := s.task.Resume(ctx, &task.ResumeRequest{
+	if _, err := s.taskService().Resume(ctx, &task.ResumeRequest{
 		ID: s.ID(),
 	}); err != nil {
 		return errdefs.FromGRPC(err)
@@ -372,7 +439,7 @@ func (s *shimTask) Resume(ctx context.Context) error {
 }
 
 func (s *shimTask) Start(ctx context.Context) error {
-	_, err := s.task.Start(ctx, &task.StartRequest{
+	_, err := s.taskService().Start(ctx, &task.StartRequest{
 		ID: s.ID(),
 	})
 	if err != nil {
@@ -382,7 +449,7 @@ func (s *shimTask) Start(ctx context.Context) error {
 }
 
 func (s *shimTask) Kill(ctx context.Context, signal uint32, all bool) error {
-	if _, err := s.task.Kill(ctx, &task.KillRequest{
+	if _, err := s.taskService().Kill(ctx, &task.KillRequest{
 		ID:     s.ID(),
 		Signal: signal,
 		All:    all,
@@ -405,7 +472,7 @@ func (s *shimTask) Exec(ctx context.Context, id string, opts runtime.ExecOpts) (
 		Terminal: opts.IO.Terminal,
 		Spec:     opts.Spec,
 	}
-	if _, err := s.task.Exec(ctx, request); err != nil {
+	if _, err := s.taskService().Exec(ctx, request); err != nil {
 		return nil, errdefs.FromGRPC(err)
 	}
 	return &process{
@@ -415,8 +482,12 @@ func (s *shimTask) Exec(ctx context.Context, id string, opts runtime.ExecOpts) (
 }
 
 func (s *shimTask) Pids(ctx context.Context) ([]runtime.ProcessInfo, error) {
-	resp, err := s.task.Pids(ctx, &task.PidsRequest{
-		ID: s.ID(),
+	var resp *task.PidsResponse
+	err := s.retry(ctx, func(t task.TaskService) (err error) {
+		resp, err = t.Pids(ctx, &task.PidsRequest{
+			ID: s.ID(),
+		})
+		return err
 	})
 	if err != nil {
 		return nil, errdefs.FromGRPC(err)
@@ -432,7 +503,7 @@ func (s *shimTask) Pids(ctx context.Context) ([]runtime.ProcessInfo, error) {
 }
 
 func (s *shimTask) ResizePty(ctx context.Context, size runtime.ConsoleSize) error {
-	_, err := s.task.ResizePty(ctx, &task.ResizePtyRequest{
+	_, err := s.taskService().ResizePty(ctx, &task.ResizePtyRequest{
 		ID:     s.ID(),
 		Width:  size.Width,
 		Height: size.Height,
@@ -444,7 +515,7 @@ func (s *shimTask) ResizePty(ctx context.Context, size runtime.ConsoleSize) erro
 }
 
 func (s *shimTask) CloseIO(ctx context.Context) error {
-	_, err := s.task.CloseIO(ctx, &task.CloseIORequest{
+	_, err := s.taskService().CloseIO(ctx, &task.CloseIORequest{
 		ID:    s.ID(),
 		Stdin: true,
 	})
@@ -459,7 +530,7 @@ func (s *shimTask) Wait(ctx context.Context) (*runtime.Exit, error) {
 	if err != nil {
 		return nil, err
 	}
-	response, err := s.task.Wait(ctx, &task.WaitRequest{
+	response, err := s.taskService().Wait(ctx, &task.WaitRequest{
 		ID: s.ID(),
 	})
 	if err != nil {
@@ -478,14 +549,14 @@ func (s *shimTask) Checkpoint(ctx context.Context, path string, options *ptypes.
 		Path:    path,
 		Options: options,
 	}
-	if _, err := s.task.Checkpoint(ctx, request); err != nil {
+	if _, err := s.taskService().Checkpoint(ctx, request); err != nil {
 		return errdefs.FromGRPC(err)
 	}
 	return nil
 }
 
 func (s *shimTask) Update(ctx context.Context, resources *ptypes.Any, annotations map[string]string) error {
-	if _, err := s.task.Update(ctx, &task.UpdateTaskRequest{
+	if _, err := s.taskService().Update(ctx, &task.UpdateTaskRequest{
 		ID:          s.ID(),
 		Resources:   resources,
 		Annotations: annotations,
@@ -496,8 +567,12 @@ func (s *shimTask) Update(ctx context.Context, resources *ptypes.Any, annotation
 }
 
 func (s *shimTask) Stats(ctx context.Context) (*ptypes.Any, error) {
-	response, err := s.task.Stats(ctx, &task.StatsRequest{
-		ID: s.ID(),
+	var response *task.StatsResponse
+	err := s.retry(ctx, func(t task.TaskService) (err error) {
+		response, err = t.Stats(ctx, &task.StatsRequest{
+			ID: s.ID(),
+		})
+		return err
 	})
 	if err != nil {
 		return nil, errdefs.FromGRPC(err)
@@ -517,8 +592,12 @@ func (s *shimTask) Process(ctx context.Context, id string) (runtime.ExecProcess,
 }
 
 func (s *shimTask) State(ctx context.Context) (runtime.State, error) {
-	response, err := s.task.State(ctx, &task.StateRequest{
-		ID: s.ID(),
+	var response *task.StateResponse
+	err := s.retry(ctx, func(t task.TaskService) (err error) {
+		response, err = t.State(ctx, &task.StateRequest{
+			ID: s.ID(),
+		})
+		return err
 	})
 	if err != nil {
 		if !errors.Is(err, ttrpc.ErrClosed) {
@@ -46,3 +46,29 @@ func checkCopyShimLogError(ctx context.Context, err error) error {
 	}
 	return err
 }
+
+// peerPid returns the pid of the process at the other end of a connection to
+// a shim socket, or zero if it cannot be told.
+func peerPid(conn net.Conn) int {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	var cred *unix.Ucred
+	raw.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil || cred == nil {
+		return 0
+	}
+	return int(cred.Pid)
+}
+
+// processGone reports whether the process pid no longer exists.
+func processGone(pid int) bool {
+	return pid > 0 && unix.Kill(pid, 0) == unix.ESRCH
+}
@@ -0,0 +1,124 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package shim
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/fifo"
+	"github.com/kata-contrib/runs/pkg/util"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	shimLogFilename       = "shim.log"
+	shimLoggerPidFilename = "shim-log.pid"
+)
+
+// ShimLogConfig configures how the logs of a shim are persisted.
+type ShimLogConfig struct {
+	// MaxSize is the size in bytes after which the log is rotated
+	MaxSize int64
+	// MaxFiles is the number of rotated logs to keep
+	MaxFiles int
+	// Forward also writes every log line to the runs logger
+	Forward bool
+}
+
+// ShimLogger takes over the log pipe of a shim that is about to be started
+// and persists it to the log file at path. The returned function stops the
+// logger if the shim fails to start.
+type ShimLogger func(ctx context.Context, bundle *Bundle, path string) (stop func(), err error)
+
+// ShimLogPath returns the path of the persistent log of a container's shim.
+func ShimLogPath(root, id string) string {
+	return filepath.Join(root, id, shimLogFilename)
+}
+
+// CreateShimLogPipe creates the log pipe of a shim so that it exists before
+// the shim and its logger open it.
+func CreateShimLogPipe(bundle *Bundle) error {
+	if err := unix.Mkfifo(filepath.Join(bundle.Path, "log"), 0700); err != nil && !errors.Is(err, unix.EEXIST) {
+		return err
+	}
+	return nil
+}
+
+// ServeShimLog copies the log pipe of a shim into the rotated log file at path
+// until the shim closes the pipe.
+func ServeShimLog(ctx context.Context, bundle *Bundle, path string, config ShimLogConfig) error {
+	pidFile := filepath.Join(bundle.Path, shimLoggerPidFilename)
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0600); err != nil {
+		return err
+	}
+	defer os.Remove(pidFile)
+
+	w, err := util.NewRotatingFile(path, config.MaxSize, config.MaxFiles, false)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	// Open read-only so that the copy ends once the shim closes its end.
+	f, err := fifo.OpenFifo(ctx, filepath.Join(bundle.Path, "log"), unix.O_RDONLY|unix.O_CREAT, 0700)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entry := logrus.WithField("id", bundle.ID)
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if len(line) > 0 {
+			if _, werr := io.WriteString(w, line); werr != nil {
+				return werr
+			}
+			if config.Forward {
+				entry.Info(strings.TrimRight(line, "\n"))
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return checkCopyShimLogError(ctx, err)
+		}
+	}
+}
+
+// shimLoggerRunning reports whether a shim logger owns the log pipe of the
+// bundle, in which case it must not be read by anyone else.
+func shimLoggerRunning(bundle *Bundle) bool {
+	data, err := os.ReadFile(filepath.Join(bundle.Path, shimLoggerPidFilename))
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return false
+	}
+	return unix.Kill(pid, 0) == nil
+}
@@ -0,0 +1,218 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package shim
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/runtime"
+	"github.com/kata-contrib/runs/pkg/util"
+	"golang.org/x/sys/unix"
+)
+
+const lockFilename = "state.lock"
+
+// UnknownStatus is the status of a container whose shim can no longer be
+// reached. It is the zero status, which the runtime package leaves unused.
+const UnknownStatus runtime.Status = 0
+
+// LoadState reads the saved state of the container with the given id from
+// the state root.
+func LoadState(root, id string) (*State, error) {
+	f, err := os.Open(filepath.Join(root, id, stateFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var state State
+	if err := json.NewDecoder(f).Decode(&state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// lockContainer takes an exclusive lock on the state of a container, which
+// the events server and publishing shims update concurrently.
+func lockContainer(root, id string) (unlock func(), err error) {
+	f, err := os.OpenFile(filepath.Join(root, id, lockFilename), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// UpdateState applies update to the saved state of a container while holding
+// its lock.
+func UpdateState(root, id string, update func(*State) error) error {
+	unlock, err := lockContainer(root, id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	state, err := LoadState(root, id)
+	if err != nil {
+		return err
+	}
+	if err := update(state); err != nil {
+		return err
+	}
+	return writeState(root, id, state)
+}
+
+// updateStatus rewrites the status in the saved state of a container.
+func updateStatus(root, id string, status runtime.Status) error {
+	return UpdateState(root, id, func(state *State) error {
+		state.Status = status
+		return nil
+	})
+}
+
+// LogDropped counts the bytes of each stream of a container that its monitor
+// left out of the log.
+type LogDropped struct {
+	Stdout uint64 `json:"stdout"`
+	Stderr uint64 `json:"stderr"`
+}
+
+// OnExit describes what runs does once the init process of a container exits.
+type OnExit struct {
+	// Remove deletes the task, the shim, the bundle files and the state
+	Remove bool `json:"remove,omitempty"`
+	// Command is a host command run with the container id, exit status and
+	// state
+	Command string `json:"command,omitempty"`
+	// Handled is set once the actions were claimed, so that they run once
+	Handled bool `json:"handled,omitempty"`
+}
+
+// ClaimOnExit marks the on-exit actions of a container as handled and returns
+// its state, or nil when it has none or they were claimed before. A known
+// exit is recorded in the state unless an exit event was recorded already.
+func ClaimOnExit(root, id string, exit *runtime.Exit) (*State, error) {
+	var claimed *State
+	err := UpdateState(root, id, func(state *State) error {
+		if state.OnExit == nil || state.OnExit.Handled {
+			return nil
+		}
+		state.OnExit.Handled = true
+		if exit != nil && state.Status != runtime.StoppedStatus {
+			state.Status = runtime.StoppedStatus
+			state.ExitStatus = exit.Status
+			state.ExitedAt = exit.Timestamp
+			state.OOMKilled = oomKilled(state)
+		}
+		claimed = state
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// writeState atomically replaces the saved state of a container.
+func writeState(root, id string, state *State) error {
+	dir := filepath.Join(root, id)
+	f, err := os.CreateTemp(dir, stateFilename)
+	if err != nil {
+		return err
+	}
+	if err := util.WriteJSON(f, state); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(dir, stateFilename))
+}
+
+// SandboxMembers returns the other containers in the sandbox of the
+// container with the given id: the sandbox and the containers that joined it
+// when the container joined one, or the containers that joined it when it is
+// a sandbox itself, including one created without a sandbox id.
+func SandboxMembers(root, id string) ([]string, error) {
+	state, err := LoadState(root, id)
+	if err != nil {
+		return nil, err
+	}
+	sandboxID := state.SandboxID
+	if sandboxID == "" {
+		sandboxID = id
+	}
+	ids, err := sandboxMembers(root, sandboxID)
+	if err != nil {
+		return nil, err
+	}
+	var members []string
+	hasSandbox := false
+	for _, member := range ids {
+		if member == sandboxID {
+			hasSandbox = true
+		}
+		if member != id {
+			members = append(members, member)
+		}
+	}
+	// A sandbox created without a sandbox id does not name itself.
+	if !hasSandbox && sandboxID != id {
+		if _, err := LoadState(root, sandboxID); err == nil {
+			members = append(members, sandboxID)
+		}
+	}
+	return members, nil
+}
+
+// sandboxMembers returns the ids of all containers in the state root that
+// belong to the given sandbox, including the sandbox container itself.
+func sandboxMembers(root, sandboxID string) ([]string, error) {
+	list, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, item := range list {
+		if !item.IsDir() {
+			continue
+		}
+		state, err := LoadState(root, item.Name())
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// Not yet created, or racing with a delete.
+				continue
+			}
+			return nil, err
+		}
+		if state.SandboxID == sandboxID {
+			ids = append(ids, item.Name())
+		}
+	}
+	return ids, nil
+}
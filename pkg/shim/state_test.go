@@ -0,0 +1,46 @@
+package shim
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSandboxMembers(t *testing.T) {
+	root := t.TempDir()
+	for id, sandboxID := range map[string]string{
+		// created without --sandbox
+		"pod":   "",
+		"app":   "pod",
+		"proxy": "pod",
+		// created with --sandbox naming itself
+		"pod2":  "pod2",
+		"app2":  "pod2",
+		"alone": "",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, id), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeState(root, id, &State{SandboxID: sandboxID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, want := range map[string][]string{
+		"pod":   {"app", "proxy"},
+		"app":   {"pod", "proxy"},
+		"pod2":  {"app2"},
+		"app2":  {"pod2"},
+		"alone": nil,
+	} {
+		got, err := SandboxMembers(root, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("SandboxMembers(%s) = %v, want %v", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/kata-contrib/runs/pkg/config"
+	"github.com/kata-contrib/runs/pkg/shim"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultQueueSize = 64
+	defaultTimeout   = 5 * time.Second
+	retryDelay       = 100 * time.Millisecond
+)
+
+// Sink delivers encoded events to one destination.
+type Sink interface {
+	Send(ctx context.Context, entry *shim.JournalEntry, data []byte) error
+	Close() error
+}
+
+// New returns the sink described by c.
+func New(c config.Sink) (Sink, error) {
+	switch c.Type {
+	case "file":
+		if c.Path == "" {
+			return nil, errors.New("file sink needs a path")
+		}
+		return &fileSink{path: c.Path}, nil
+	case "unix", "unixgram":
+		if c.Path == "" {
+			return nil, fmt.Errorf("%s sink needs a path", c.Type)
+		}
+		return &socketSink{network: c.Type, path: c.Path}, nil
+	case "webhook":
+		if c.URL == "" {
+			return nil, errors.New("webhook sink needs a url")
+		}
+		return &webhookSink{url: c.URL}, nil
+	case "exec":
+		if c.Path == "" {
+			return nil, errors.New("exec sink needs a path")
+		}
+		return &execSink{path: c.Path, args: c.Args}, nil
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", c.Type)
+	}
+}
+
+// Dispatcher delivers events to the configured sinks. Every sink has a
+// bounded queue drained by its own goroutine, so that a slow sink delays
+// neither the others nor the caller.
+type Dispatcher struct {
+	queues []*queue
+	wg     sync.WaitGroup
+
+	// mu guards closed against Publish racing with Close
+	mu     sync.RWMutex
+	closed bool
+}
+
+type queue struct {
+	name    string
+	config  config.Sink
+	sink    Sink
+	entries chan *shim.JournalEntry
+}
+
+// NewDispatcher starts delivering to the sinks of configs.
+func NewDispatcher(configs []config.Sink) (*Dispatcher, error) {
+	d := &Dispatcher{}
+	for i, c := range configs {
+		for _, topic := range c.Topics {
+			if _, err := path.Match(topic, ""); err != nil {
+				return nil, fmt.Errorf("sink %d: invalid topic filter %q: %w", i, topic, err)
+			}
+		}
+		s, err := New(c)
+		if err != nil {
+			d.Close(context.Background())
+			return nil, fmt.Errorf("sink %d: %w", i, err)
+		}
+		size := c.QueueSize
+		if size <= 0 {
+			size = defaultQueueSize
+		}
+		q := &queue{
+			name:    fmt.Sprintf("%d/%s", i, c.Type),
+			config:  c,
+			sink:    s,
+			entries: make(chan *shim.JournalEntry, size),
+		}
+		d.queues = append(d.queues, q)
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			q.run()
+		}()
+	}
+	return d, nil
+}
+
+// Publish queues an event for the sinks whose filters match its topic. It
+// never blocks: events for a sink whose queue is full are dropped, and so are
+// events published after Close.
+func (d *Dispatcher) Publish(entry *shim.JournalEntry) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		logrus.WithField("topic", entry.Topic).Debug("dispatcher closed, dropping event")
+		return
+	}
+	for _, q := range d.queues {
+		if !q.matches(entry.Topic) {
+			continue
+		}
+		select {
+		case q.entries <- entry:
+		default:
+			logrus.WithField("sink", q.name).WithField("topic", entry.Topic).Warn("sink queue full, dropping event")
+		}
+	}
+}
+
+// Close stops accepting events and waits until the queued ones are delivered
+// or ctx is done.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, q := range d.queues {
+			close(q.entries)
+		}
+	}
+	d.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("events left undelivered: %w", ctx.Err())
+	}
+}
+
+func (q *queue) matches(topic string) bool {
+	if len(q.config.Topics) == 0 {
+		return true
+	}
+	for _, pattern := range q.config.Topics {
+		if ok, _ := path.Match(pattern, topic); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *queue) run() {
+	defer q.sink.Close()
+	for entry := range q.entries {
+		if err := q.deliver(entry); err != nil {
+			logrus.WithError(err).WithField("sink", q.name).WithField("topic", entry.Topic).Warn("failed to deliver event")
+		}
+	}
+}
+
+// deliver sends an event, retrying with a growing delay.
+func (q *queue) deliver(entry *shim.JournalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	timeout := time.Duration(q.config.Timeout)
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err = q.sink.Send(ctx, entry, data)
+		cancel()
+		if err == nil || attempt >= q.config.Retries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// fileSink appends events to a file, one JSON object per line.
+type fileSink struct {
+	path string
+}
+
+func (s *fileSink) Send(_ context.Context, _ *shim.JournalEntry, data []byte) error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *fileSink) Close() error {
+	return nil
+}
+
+// socketSink sends events to a unix socket: a datagram per event, or a line
+// per event on a stream kept open between events.
+type socketSink struct {
+	network string
+	path    string
+	conn    net.Conn
+}
+
+func (s *socketSink) Send(ctx context.Context, _ *shim.JournalEntry, data []byte) error {
+	if s.conn == nil {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, s.network, s.path)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetWriteDeadline(deadline)
+	}
+	if s.network == "unix" {
+		data = append(data, '\n')
+	}
+	if _, err := s.conn.Write(data); err != nil {
+		// Redial on the next attempt.
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *socketSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// webhookSink POSTs every event as JSON.
+type webhookSink struct {
+	url string
+}
+
+func (s *webhookSink) Send(ctx context.Context, _ *shim.JournalEntry, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s: %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	return nil
+}
+
+// execSink runs a command for every event, with the event on its stdin.
+type execSink struct {
+	path string
+	args []string
+}
+
+func (s *execSink) Send(ctx context.Context, entry *shim.JournalEntry, data []byte) error {
+	cmd := exec.CommandContext(ctx, s.path, s.args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(),
+		"RUNS_EVENT_TOPIC="+entry.Topic,
+		"RUNS_CONTAINER_ID="+entry.ID,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s: %w", s.path, bytes.TrimSpace(out), err)
+	}
+	return nil
+}
+
+func (s *execSink) Close() error {
+	return nil
+}
@@ -0,0 +1,95 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kata-contrib/runs/pkg/config"
+	"github.com/kata-contrib/runs/pkg/shim"
+)
+
+func TestWebhookSink(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []shim.JournalEntry
+		attempts int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("content type = %q, want application/json", ct)
+		}
+		// The first delivery fails, to be retried.
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+			return
+		}
+		var entry shim.JournalEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			t.Errorf("decode body %q: %v", data, err)
+			return
+		}
+		received = append(received, entry)
+	}))
+	defer server.Close()
+
+	d, err := NewDispatcher([]config.Sink{{
+		Type:    "webhook",
+		URL:     server.URL,
+		Topics:  []string{"/tasks/*"},
+		Retries: 1,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Publish(&shim.JournalEntry{ID: "c1", Topic: "/tasks/exit", Event: json.RawMessage(`{"exit_status":3}`)})
+	d.Publish(&shim.JournalEntry{ID: "c1", Topic: "/containers/create", Event: json.RawMessage(`{}`)})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+	if len(received) != 1 {
+		t.Fatalf("received %d events, want 1", len(received))
+	}
+	if got := received[0]; got.ID != "c1" || got.Topic != "/tasks/exit" || string(got.Event) != `{"exit_status":3}` {
+		t.Errorf("received %+v", got)
+	}
+}
+
+func TestPublishAfterClose(t *testing.T) {
+	d, err := NewDispatcher([]config.Sink{{Type: "file", Path: t.TempDir() + "/events"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Dropped, not sent on a closed queue.
+	d.Publish(&shim.JournalEntry{ID: "c1", Topic: "/tasks/exit"})
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,193 @@
+package util
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// compressedSuffix is appended to the name of a compressed rotated file.
+const compressedSuffix = ".gz"
+
+// RotatingFile is an io.WriteCloser that rotates the file it writes to once
+// it grows past a maximum size. Rotated files are renamed to path.1, path.2,
+// ... with path.1 being the most recent, and at most maxFiles of them are
+// kept. When compressing, rotated files are gzipped to path.1.gz, path.2.gz,
+// ...
+type RotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	compress bool
+	f        *os.File
+	size     int64
+}
+
+// NewRotatingFile opens path for appending. A maxSize of zero or less
+// disables rotation.
+func NewRotatingFile(path string, maxSize int64, maxFiles int, compress bool) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		compress: compress,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.size = st.Size()
+	return nil
+}
+
+// Write writes p to the current file, rotating it first if p would take it
+// past the maximum size.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+	if r.maxFiles > 0 {
+		for i := r.maxFiles - 1; i > 0; i-- {
+			if err := os.Rename(r.rotatedName(i), r.rotatedName(i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(r.path, RotatedName(r.path, 1)); err != nil {
+			return err
+		}
+		if r.compress {
+			if err := compressFile(RotatedName(r.path, 1)); err != nil {
+				return err
+			}
+		}
+	} else if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return r.open()
+}
+
+// Close closes the current file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+func (r *RotatingFile) rotatedName(n int) string {
+	if r.compress {
+		return CompressedName(RotatedName(r.path, n))
+	}
+	return RotatedName(r.path, n)
+}
+
+// compressFile replaces path with its gzipped copy.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(CompressedName(path), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	zw, err := gzip.NewWriterLevel(dst, gzip.BestSpeed)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+	return os.Remove(path)
+}
+
+// RotatedName returns the name of the n-th rotated file of path.
+func RotatedName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// CompressedName returns the name of the compressed copy of a rotated file.
+func CompressedName(path string) string {
+	return path + compressedSuffix
+}
+
+// OpenRotated opens a log or one of its rotated files for reading,
+// decompressing it when it is compressed.
+func OpenRotated(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, compressedSuffix) {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipFile{Reader: zr, f: f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
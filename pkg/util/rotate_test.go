@@ -0,0 +1,142 @@
+package util
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readRotated returns the content of a log or of one of its rotated files.
+func readRotated(t *testing.T, path string) string {
+	t.Helper()
+	f, err := OpenRotated(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotatingFile(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		name := "plain"
+		if compress {
+			name = "compressed"
+		}
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "container.log")
+			r, err := NewRotatingFile(path, 10, 2, compress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "six\n"} {
+				if _, err := r.Write([]byte(s)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			rotated := func(n int) string {
+				if compress {
+					return CompressedName(RotatedName(path, n))
+				}
+				return RotatedName(path, n)
+			}
+			// one and two went away with the third rotation.
+			for file, want := range map[string]string{
+				path:       "six\n",
+				rotated(1): "four\nfive\n",
+				rotated(2): "three\n",
+			} {
+				if got := readRotated(t, file); got != want {
+					t.Errorf("%s: %q, want %q", filepath.Base(file), got, want)
+				}
+			}
+			if _, err := os.Stat(rotated(3)); !os.IsNotExist(err) {
+				t.Errorf("more rotated files kept than asked: %v", err)
+			}
+			if compress {
+				// Only the compressed copies are kept.
+				if _, err := os.Stat(RotatedName(path, 1)); !os.IsNotExist(err) {
+					t.Errorf("uncompressed rotated file left behind: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRotatingFileLimits(t *testing.T) {
+	dir := t.TempDir()
+
+	// No maximum size, no rotation.
+	path := filepath.Join(dir, "unlimited.log")
+	r, err := NewRotatingFile(path, 0, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("a long line of output\n"))
+	r.Write([]byte("and another one\n"))
+	r.Close()
+	if got := readRotated(t, path); got != "a long line of output\nand another one\n" {
+		t.Errorf("unlimited log %q", got)
+	}
+	if _, err := os.Stat(RotatedName(path, 1)); !os.IsNotExist(err) {
+		t.Errorf("unlimited log rotated: %v", err)
+	}
+
+	// No rotated files kept, the log starts over.
+	path = filepath.Join(dir, "truncated.log")
+	if r, err = NewRotatingFile(path, 10, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("first line\n"))
+	r.Write([]byte("second\n"))
+	r.Close()
+	if got := readRotated(t, path); got != "second\n" {
+		t.Errorf("truncated log %q", got)
+	}
+	if _, err := os.Stat(RotatedName(path, 1)); !os.IsNotExist(err) {
+		t.Errorf("log rotated without rotated files to keep: %v", err)
+	}
+
+	// A write larger than the maximum still goes to a file of its own.
+	path = filepath.Join(dir, "large.log")
+	if r, err = NewRotatingFile(path, 4, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("ab\n"))
+	r.Write([]byte("larger than the maximum\n"))
+	r.Close()
+	if got := readRotated(t, path); got != "larger than the maximum\n" {
+		t.Errorf("large write %q", got)
+	}
+	if got := readRotated(t, RotatedName(path, 1)); got != "ab\n" {
+		t.Errorf("rotated before large write %q", got)
+	}
+
+	// Reopening appends and keeps counting the size.
+	path = filepath.Join(dir, "reopened.log")
+	if r, err = NewRotatingFile(path, 10, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("12345678\n"))
+	r.Close()
+	if r, err = NewRotatingFile(path, 10, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("next\n"))
+	r.Close()
+	if got := readRotated(t, RotatedName(path, 1)); got != "12345678\n" {
+		t.Errorf("reopened log rotated %q", got)
+	}
+	if _, err := r.Write([]byte("closed\n")); err != os.ErrClosed {
+		t.Errorf("write after close: %v", err)
+	}
+}